@@ -3,10 +3,14 @@ module github.com/yz271544/edge-auto-gw
 go 1.16
 
 require (
+	github.com/gogo/protobuf v1.3.2
+	github.com/google/go-cmp v0.5.5
 	github.com/kubeedge/beehive v0.0.0
 	github.com/kubeedge/kubeedge v1.6.2
+	github.com/prometheus/client_golang v1.7.1
 	github.com/spf13/cast v1.3.1
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
 	istio.io/api v0.0.0-20210131044048-bfeb10697307
 	istio.io/client-go v0.0.0-20210218000043-b598dd019200
 	k8s.io/api v0.21.1
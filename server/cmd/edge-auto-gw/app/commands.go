@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/config"
+	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+// setSubcommandUsage prints the usage of a subcommand with its own flags, the root
+// command prints its named flag sections which do not apply to the subcommands
+func setSubcommandUsage(cmd *cobra.Command) {
+	cmd.SetUsageFunc(func(cmd *cobra.Command) error {
+		fmt.Fprintf(cmd.OutOrStderr(), "Usage:\n  %s\n\nFlags:\n%s", cmd.UseLine(), cmd.LocalFlags().FlagUsages())
+		return nil
+	})
+	cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\n\nUsage:\n  %s\n\nFlags:\n%s", cmd.Long, cmd.UseLine(), cmd.LocalFlags().FlagUsages())
+	})
+}
+
+// printSubcommands lists the subcommands of cmd in its help
+func printSubcommands(cmd *cobra.Command) {
+	if !cmd.HasAvailableSubCommands() {
+		return
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "\nAvailable Commands:\n")
+	for _, c := range cmd.Commands() {
+		if c.IsAvailableCommand() {
+			fmt.Fprintf(cmd.OutOrStdout(), "  %-10s %s\n", c.Name(), c.Short)
+		}
+	}
+}
+
+// clusterOptions are the flags of the subcommands which connect to the cluster
+type clusterOptions struct {
+	configFile string
+	kubeconfig string
+	master     string
+}
+
+func (o *clusterOptions) addFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.configFile, "config-file", "", "The edge-auto-gw configuration file to take the cluster connection and module config from, optional.")
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+	fs.StringVar(&o.master, "master", "", "The address of the Kubernetes API server, overrides any value in kubeconfig.")
+}
+
+// config returns the edge-auto-gw config, the flags override the configuration file
+func (o *clusterOptions) config() (*config.EdgeAutoGwConfig, error) {
+	cfg := config.NewEdgeAutoGwConfig()
+	if o.configFile != "" {
+		if err := cfg.Parse(o.configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	kubeconfig := o.kubeconfig
+	if kubeconfig == "" && cfg.KubeAPIConfig.KubeConfig == "" {
+		kubeconfig = os.Getenv(clientcmd.RecommendedConfigPathEnvVar)
+		if _, err := os.Stat(clientcmd.RecommendedHomeFile); kubeconfig == "" && err == nil {
+			kubeconfig = clientcmd.RecommendedHomeFile
+		}
+	}
+	if kubeconfig != "" {
+		cfg.KubeAPIConfig.KubeConfig = kubeconfig
+	}
+	if o.master != "" {
+		cfg.KubeAPIConfig.Master = o.master
+	}
+	return cfg, nil
+}
+
+// clients returns the edge-auto-gw config and the clients of the cluster it points to
+func (o *clusterOptions) clients() (*config.EdgeAutoGwConfig, *informers.Manager, error) {
+	cfg, err := o.config()
+	if err != nil {
+		return nil, nil, err
+	}
+	ifm, err := informers.NewManager(cfg.KubeAPIConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, ifm, nil
+}
+
+// listClusterState reads the services edge-auto-gw selects and all istio networking
+// objects of the cluster, it only reads
+func listClusterState(ctx context.Context, ifm *informers.Manager) (*manager.ClusterState, error) {
+	state := &manager.ClusterState{}
+
+	svcs, err := ifm.GetKubeClient().CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: controller.ServiceSelector().String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list services failed: %v", err)
+	}
+	for i := range svcs.Items {
+		state.Services = append(state.Services, &svcs.Items[i])
+	}
+
+	networking := ifm.GetIstioClient().NetworkingV1alpha3()
+	gws, err := networking.Gateways(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list gateways failed: %v", err)
+	}
+	for i := range gws.Items {
+		state.Gateways = append(state.Gateways, &gws.Items[i])
+	}
+	vss, err := networking.VirtualServices(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list virtualservices failed: %v", err)
+	}
+	for i := range vss.Items {
+		state.VirtualServices = append(state.VirtualServices, &vss.Items[i])
+	}
+	drs, err := networking.DestinationRules(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list destinationrules failed: %v", err)
+	}
+	for i := range drs.Items {
+		state.DestinationRules = append(state.DestinationRules, &drs.Items[i])
+	}
+	return state, nil
+}
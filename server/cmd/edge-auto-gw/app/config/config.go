@@ -3,14 +3,18 @@ package config
 import (
 	"io/ioutil"
 	"path"
+	"time"
 
 	"github.com/kubeedge/kubeedge/common/constants"
 	"github.com/kubeedge/kubeedge/pkg/apis/componentconfig/cloudcore/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	componentbaseconfig "k8s.io/component-base/config"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/yaml"
 
+	autoConstants "github.com/yz271544/edge-auto-gw/server/common/constants"
 	autogwconfig "github.com/yz271544/edge-auto-gw/server/pkg/autogw/config"
 )
 
@@ -19,6 +23,9 @@ const (
 	APIVersion           = "v1alpha1"
 	Kind                 = "EdgeAutoGw"
 	DefaultConfigMapName = "edge-auto-gw-cfg"
+	DefaultLeaseName     = "edge-auto-gw"
+	// DefaultHealthzBindAddress is the default address of the health endpoints
+	DefaultHealthzBindAddress = "0.0.0.0:10550"
 )
 
 // EdgeAutoGwConfig indicates the config of edgeAutoGw which get from edgeAutoGw config file
@@ -41,6 +48,36 @@ type CommonConfig struct {
 	// which contains all the configuration information of edge-auto-gw
 	// default edge-auto-gw-cfg
 	ConfigMapName string `json:"configMapName,omitempty"`
+	// HealthzBindAddress indicates the address /healthz and /readyz are served on
+	// default 0.0.0.0:10550
+	HealthzBindAddress string `json:"healthzBindAddress,omitempty"`
+	// ShutdownGracePeriod indicates how long the reconciles in flight may take to finish
+	// after SIGTERM, keep it below the terminationGracePeriodSeconds of the pod
+	// default 20s
+	ShutdownGracePeriod metav1.Duration `json:"shutdownGracePeriod,omitempty"`
+	// LeaderElection indicates the lease based leader election between edge-auto-gw replicas,
+	// only the leader reconciles, the others keep their caches warm to take over
+	LeaderElection *componentbaseconfig.LeaderElectionConfiguration `json:"leaderElection,omitempty"`
+	// Sharding indicates splitting the namespaces between several active edge-auto-gw instances,
+	// it can not be used together with leader election
+	Sharding *ShardingConfig `json:"sharding,omitempty"`
+}
+
+// ShardingConfig indicates how several active edge-auto-gw instances split the namespaces
+type ShardingConfig struct {
+	// Enable indicates whether the namespaces are split between the instances
+	// default false
+	Enable bool `json:"enable"`
+	// LeaseNamespace indicates the namespace of the membership lease every instance renews
+	// default kubeedge
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+	// LeaseDuration indicates how long an instance is considered alive after its last renewal,
+	// its namespaces move to the other instances after that
+	// default 30s
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewPeriod indicates how often an instance renews its lease and reads the membership
+	// default 10s
+	RenewPeriod metav1.Duration `json:"renewPeriod,omitempty"`
 }
 
 // Modules indicates the modules of edgeAutoGw will be use
@@ -57,7 +94,24 @@ func NewEdgeAutoGwConfig() *EdgeAutoGwConfig {
 			APIVersion: path.Join(GroupName, APIVersion),
 		},
 		CommonConfig: &CommonConfig{
-			ConfigMapName: DefaultConfigMapName,
+			ConfigMapName:       DefaultConfigMapName,
+			HealthzBindAddress:  DefaultHealthzBindAddress,
+			ShutdownGracePeriod: metav1.Duration{Duration: 20 * time.Second},
+			LeaderElection: &componentbaseconfig.LeaderElectionConfiguration{
+				LeaderElect:       false,
+				LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
+				RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
+				RetryPeriod:       metav1.Duration{Duration: 2 * time.Second},
+				ResourceLock:      resourcelock.LeasesResourceLock,
+				ResourceNamespace: autoConstants.EdgeAutoGwNamespace,
+				ResourceName:      DefaultLeaseName,
+			},
+			Sharding: &ShardingConfig{
+				Enable:         false,
+				LeaseNamespace: autoConstants.EdgeAutoGwNamespace,
+				LeaseDuration:  metav1.Duration{Duration: 30 * time.Second},
+				RenewPeriod:    metav1.Duration{Duration: 10 * time.Second},
+			},
 		},
 		KubeAPIConfig: &v1alpha1.KubeAPIConfig{
 			Master:      "",
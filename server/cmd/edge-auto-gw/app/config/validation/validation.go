@@ -3,12 +3,94 @@ package validation
 import (
 	"github.com/kubeedge/kubeedge/pkg/apis/componentconfig/cloudcore/v1alpha1/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	componentbaseconfig "k8s.io/component-base/config"
 
 	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/config"
+	autogwconfig "github.com/yz271544/edge-auto-gw/server/pkg/autogw/config"
 )
 
 func ValidateEdgeAutoGwConfiguration(c *config.EdgeAutoGwConfig) field.ErrorList {
 	allErrs := field.ErrorList{}
 	allErrs = append(allErrs, validation.ValidateKubeAPIConfig(*c.KubeAPIConfig)...)
+	allErrs = append(allErrs, ValidateCommonConfig(*c.CommonConfig)...)
+	allErrs = append(allErrs, ValidateLeaderElectionConfiguration(*c.CommonConfig.LeaderElection)...)
+	allErrs = append(allErrs, ValidateShardingConfiguration(*c.CommonConfig.Sharding)...)
+	if c.CommonConfig.LeaderElection.LeaderElect && c.CommonConfig.Sharding.Enable {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("commonConfig", "sharding", "enable"), true,
+			"can not be used together with leaderElection.leaderElect"))
+	}
+	allErrs = append(allErrs, ValidateModuleEdgeAutoGw(*c.Modules.EdgeAutoConfig)...)
+	return allErrs
+}
+
+// ValidateCommonConfig validates `c` and returns an errorList if it is invalid
+func ValidateCommonConfig(c config.CommonConfig) field.ErrorList {
+	allErrs := field.ErrorList{}
+	fldPath := field.NewPath("commonConfig")
+	if c.HealthzBindAddress == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("healthzBindAddress"), ""))
+	}
+	if c.ShutdownGracePeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("shutdownGracePeriod"), c.ShutdownGracePeriod, "must be greater than 0"))
+	}
+	return allErrs
+}
+
+// ValidateLeaderElectionConfiguration validates `l` and returns an errorList if it is invalid
+func ValidateLeaderElectionConfiguration(l componentbaseconfig.LeaderElectionConfiguration) field.ErrorList {
+	if !l.LeaderElect {
+		return field.ErrorList{}
+	}
+
+	allErrs := field.ErrorList{}
+	fldPath := field.NewPath("commonConfig", "leaderElection")
+	if l.LeaseDuration.Duration <= l.RenewDeadline.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("leaseDuration"), l.LeaseDuration, "must be greater than renewDeadline"))
+	}
+	if l.RenewDeadline.Duration <= l.RetryPeriod.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewDeadline"), l.RenewDeadline, "must be greater than retryPeriod"))
+	}
+	if l.RetryPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("retryPeriod"), l.RetryPeriod, "must be greater than 0"))
+	}
+	if l.ResourceNamespace == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resourceNamespace"), ""))
+	}
+	if l.ResourceName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resourceName"), ""))
+	}
+	return allErrs
+}
+
+// ValidateShardingConfiguration validates `s` and returns an errorList if it is invalid
+func ValidateShardingConfiguration(s config.ShardingConfig) field.ErrorList {
+	if !s.Enable {
+		return field.ErrorList{}
+	}
+
+	allErrs := field.ErrorList{}
+	fldPath := field.NewPath("commonConfig", "sharding")
+	if s.LeaseDuration.Duration <= s.RenewPeriod.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("leaseDuration"), s.LeaseDuration, "must be greater than renewPeriod"))
+	}
+	if s.RenewPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewPeriod"), s.RenewPeriod, "must be greater than 0"))
+	}
+	if s.LeaseNamespace == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("leaseNamespace"), ""))
+	}
+	return allErrs
+}
+
+// ValidateModuleEdgeAutoGw validates `a` and returns an errorList if it is invalid
+func ValidateModuleEdgeAutoGw(a autogwconfig.EdgeAutoGwConfig) field.ErrorList {
+	if !a.Enable {
+		return field.ErrorList{}
+	}
+
+	allErrs := field.ErrorList{}
+	if a.Workers <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("modules", "edgeAutoGw", "workers"), a.Workers, "must be greater than 0"))
+	}
 	return allErrs
 }
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/config"
+	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/doctor"
+)
+
+type doctorOptions struct {
+	clusterOptions
+}
+
+func newDoctorCommand() *cobra.Command {
+	o := &doctorOptions{}
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the cluster is ready for edge-auto-gw",
+		Long: `doctor connects to the cluster read-only and checks the istio networking CRDs and their served
+versions, the RBAC permissions edge-auto-gw needs, the edgemesh-gateway pods, the label keys
+and every existing exposure, with a hint how to fix each failure. Permissions are reviewed for
+the current user, run it with the credentials of edge-auto-gw to check its service account.
+edge-auto-gw runs the same checks at startup. It exits non-zero if a check fails.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *doctorOptions) run(out io.Writer) error {
+	cfg, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+
+	results := doctor.Run(context.Background(), ifm.GetKubeClient(), doctorOptionsFor(cfg))
+	for _, r := range results {
+		fmt.Fprintln(out, r)
+	}
+	if doctor.Failed(results) {
+		return fmt.Errorf("edge-auto-gw can not run in this cluster, fix the failed checks")
+	}
+	return nil
+}
+
+// doctorOptionsFor returns the permissions edge-auto-gw needs with cfg
+func doctorOptionsFor(cfg *config.EdgeAutoGwConfig) doctor.Options {
+	opts := doctor.Options{ReadOnly: cfg.Modules.EdgeAutoConfig.DryRun}
+	if le := cfg.CommonConfig.LeaderElection; le != nil && le.LeaderElect {
+		opts.LeaderElectionNamespace = le.ResourceNamespace
+	}
+	if sc := cfg.CommonConfig.Sharding; sc != nil && sc.Enable {
+		opts.ShardingNamespace = sc.LeaseNamespace
+	}
+	return opts
+}
+
+// preflight runs the doctor checks at startup, a failed check stops edge-auto-gw
+// before it floods the log with errors of every reconcile
+func preflight(ctx context.Context, cfg *config.EdgeAutoGwConfig, ifm *informers.Manager) error {
+	results := doctor.Run(ctx, ifm.GetKubeClient(), doctorOptionsFor(cfg))
+	for _, r := range results {
+		switch r.Status {
+		case doctor.StatusPass:
+			klog.Infof("preflight %s", r)
+		case doctor.StatusWarn:
+			klog.Warningf("preflight %s", r)
+		default:
+			klog.Errorf("preflight %s", r)
+		}
+	}
+	if doctor.Failed(results) {
+		return fmt.Errorf("preflight checks failed, run edge-auto-gw doctor for details")
+	}
+	return nil
+}
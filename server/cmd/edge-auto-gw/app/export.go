@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+type exportOptions struct {
+	clusterOptions
+	namespace string
+	output    string
+}
+
+func newExportCommand() *cobra.Command {
+	o := &exportOptions{output: "-"}
+	cmd := &cobra.Command{
+		Use:   "export [-o FILE]",
+		Short: "Back up the edge exposures to an archive file",
+		Long: `export connects to the cluster read-only and writes every exposure to a versioned archive: the
+gateway labels of the Service and the Gateway, VirtualService and DestinationRule rendered from
+them. Services with invalid labels are reported and left out. The archive is restored with import.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", "", "Only export the exposures of this namespace, all namespaces by default.")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "The archive file to write, - writes stdout.")
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *exportOptions) run(out, errOut io.Writer) error {
+	_, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+	state, err := listClusterState(context.Background(), ifm)
+	if err != nil {
+		return err
+	}
+
+	svcs := make([]*v1.Service, 0, len(state.Services))
+	for _, svc := range state.Services {
+		if o.namespace == "" || svc.Namespace == o.namespace {
+			svcs = append(svcs, svc)
+		}
+	}
+	archive, labelErrors := manager.NewArchive(svcs)
+	for _, e := range labelErrors {
+		fmt.Fprintf(errOut, "skip service %s: %s\n", e.Name, e.Error)
+	}
+
+	data, err := yaml.Marshal(archive)
+	if err != nil {
+		return fmt.Errorf("marshal archive failed: %v", err)
+	}
+	if o.output == "-" {
+		_, err = out.Write(data)
+		return err
+	}
+	if err := os.WriteFile(o.output, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(errOut, "exported %d exposures to %s\n", len(archive.Exposures), o.output)
+	return nil
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+const (
+	// importLabels restores the gateway labels of the services, edge-auto-gw generates the objects
+	importLabels = "labels"
+	// importObjects restores the generated objects directly, the services are left as they are
+	importObjects = "objects"
+)
+
+type importOptions struct {
+	clusterOptions
+	filename string
+	mode     string
+	dryRun   bool
+}
+
+// importSummary counts what an import did
+type importSummary struct {
+	labeled, unchanged, objects, conflicts, missing int
+}
+
+func newImportCommand() *cobra.Command {
+	o := &importOptions{filename: "-", mode: importLabels}
+	cmd := &cobra.Command{
+		Use:   "import [-f FILE]",
+		Short: "Restore the edge exposures of an archive file",
+		Long: `import replays an archive written by export into the cluster. In labels mode the gateway labels
+are set on the Services and edge-auto-gw generates the resources, in objects mode the Gateway,
+VirtualService and DestinationRule are rendered for the Services and applied directly. A Service
+which is missing or carries different gateway labels, and a resource which is not managed by
+edge-auto-gw, is reported as a conflict and left alone. It exits non-zero if there are conflicts.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.filename, "filename", "f", o.filename, "The archive file to import, - reads stdin.")
+	cmd.Flags().StringVar(&o.mode, "mode", o.mode, "What to restore, labels or objects.")
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", false, "Only report what would be restored.")
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *importOptions) run(out io.Writer) error {
+	if o.mode != importLabels && o.mode != importObjects {
+		return fmt.Errorf("unknown import mode %q, use labels or objects", o.mode)
+	}
+	archive, err := readArchive(o.filename)
+	if err != nil {
+		return err
+	}
+
+	cfg, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	summary := &importSummary{}
+	for i := range archive.Exposures {
+		if err := o.importExposure(ctx, out, ifm, &archive.Exposures[i], cfg.Modules.EdgeAutoConfig.Adopt, summary); err != nil {
+			return err
+		}
+	}
+
+	verb := "Import"
+	if o.dryRun {
+		verb = "Import (dry-run)"
+	}
+	fmt.Fprintf(out, "%s: %d labeled, %d unchanged, %d objects applied, %d conflicts, %d missing services.\n",
+		verb, summary.labeled, summary.unchanged, summary.objects, summary.conflicts, summary.missing)
+	if summary.conflicts > 0 || summary.missing > 0 {
+		return fmt.Errorf("%d of %d exposures were not restored", summary.conflicts+summary.missing, len(archive.Exposures))
+	}
+	return nil
+}
+
+// readArchive reads and validates an exposure archive, - reads stdin
+func readArchive(filename string) (*manager.Archive, error) {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &manager.Archive{}
+	if err := yaml.UnmarshalStrict(data, archive); err != nil {
+		return nil, fmt.Errorf("decode archive %s failed: %v", filename, err)
+	}
+	if err := archive.Validate(); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// importExposure restores one archived exposure and reports the outcome, only failing
+// cluster calls are returned as errors
+func (o *importOptions) importExposure(ctx context.Context, out io.Writer, ifm *informers.Manager,
+	e *manager.ArchivedExposure, adopt bool, summary *importSummary) error {
+	services := ifm.GetKubeClient().CoreV1().Services(e.Namespace)
+	svc, err := services.Get(ctx, e.Service, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		summary.missing++
+		fmt.Fprintf(out, "! service %s/%s does not exist, create it first\n", e.Namespace, e.Service)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get service %s/%s failed: %v", e.Namespace, e.Service, err)
+	}
+
+	current := manager.GatewayLabels(svc.GetLabels())
+	labeled := reflect.DeepEqual(current, e.Labels)
+	if len(current) > 0 && !labeled {
+		summary.conflicts++
+		fmt.Fprintf(out, "! service %s/%s has the gateway labels %v instead of %v\n", e.Namespace, e.Service, current, e.Labels)
+		return nil
+	}
+
+	if o.mode == importLabels {
+		if labeled {
+			summary.unchanged++
+			fmt.Fprintf(out, "= service %s/%s is already labeled\n", e.Namespace, e.Service)
+			return nil
+		}
+		if !o.dryRun {
+			if err := labelService(ctx, ifm, svc, e.Labels); err != nil {
+				return err
+			}
+		}
+		summary.labeled++
+		fmt.Fprintf(out, "+ service %s/%s labeled %v\n", e.Namespace, e.Service, e.Labels)
+		return nil
+	}
+
+	return o.importObjects(ctx, out, ifm, svc, e, adopt, summary)
+}
+
+// labelService sets the archived gateway labels on svc
+func labelService(ctx context.Context, ifm *informers.Manager, svc *v1.Service, l map[string]string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":    svc.GetUID(),
+			"labels": l,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ifm.GetKubeClient().CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("label service %s/%s failed: %v", svc.Namespace, svc.Name, err)
+	}
+	return nil
+}
+
+// importObjects renders the archived exposure for the target service and applies its objects,
+// so they are owned by the service of this cluster
+func (o *importOptions) importObjects(ctx context.Context, out io.Writer, ifm *informers.Manager,
+	svc *v1.Service, e *manager.ArchivedExposure, adopt bool, summary *importSummary) error {
+	target := svc.DeepCopy()
+	if target.Labels == nil {
+		target.Labels = make(map[string]string)
+	}
+	for k, v := range e.Labels {
+		target.Labels[k] = v
+	}
+	exposure, err := manager.RenderExposure(target)
+	if err != nil {
+		summary.conflicts++
+		fmt.Fprintf(out, "! service %s/%s: %v\n", e.Namespace, e.Service, err)
+		return nil
+	}
+	if e.Gateway != nil && !proto.Equal(&e.Gateway.Spec, &exposure.Gateway.Spec) ||
+		e.VirtualService != nil && !proto.Equal(&e.VirtualService.Spec, &exposure.VirtualService.Spec) ||
+		e.DestinationRule != nil && !proto.Equal(&e.DestinationRule.Spec, &exposure.DestinationRule.Spec) {
+		fmt.Fprintf(out, "  service %s/%s renders differently than when it was exported, the current rendering is applied\n",
+			e.Namespace, e.Service)
+	}
+
+	items, err := manager.RestoreObjects(ctx, ifm.GetIstioClient(), exposure, adopt, o.dryRun)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		summary.unchanged++
+		fmt.Fprintf(out, "= service %s/%s is already exposed\n", e.Namespace, e.Service)
+		return nil
+	}
+	conflict := false
+	for _, item := range items {
+		switch item.Action {
+		case manager.PlanConflict:
+			conflict = true
+			fmt.Fprintf(out, "! %s %s/%s exists but is not managed by %s, service %s/%s is skipped unless adopt is enabled\n",
+				item.Kind, item.Namespace, item.Name, manager.ManagedByValue, e.Namespace, e.Service)
+		case manager.PlanCreate:
+			summary.objects++
+			fmt.Fprintf(out, "+ %s %s/%s created\n", item.Kind, item.Namespace, item.Name)
+		default:
+			summary.objects++
+			fmt.Fprintf(out, "~ %s %s/%s updated\n", item.Kind, item.Namespace, item.Name)
+		}
+	}
+	if conflict {
+		summary.conflicts++
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+type listOptions struct {
+	clusterOptions
+	namespace string
+	ports     string
+	output    string
+}
+
+func newListCommand() *cobra.Command {
+	o := &listOptions{output: "table"}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the edge exposures and their gateway ports",
+		Long: `list connects to the cluster read-only and prints every exposure: the namespace, service, service
+port, gateway port, protocol and gateway selector, with the health of the generated resources:
+in-sync, drifted, missing or invalid-labels.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", "", "Only list the exposures of this namespace, all namespaces by default.")
+	cmd.Flags().StringVar(&o.ports, "ports", "", "Only list the exposures of gateway ports in this range, e.g. 30000-30100 or 30080.")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format, one of table, json or yaml.")
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *listOptions) run(out io.Writer) error {
+	minPort, maxPort, err := parsePortRange(o.ports)
+	if err != nil {
+		return err
+	}
+	if o.output != "table" && o.output != "json" && o.output != "yaml" {
+		return fmt.Errorf("unknown output format %q, use table, json or yaml", o.output)
+	}
+
+	cfg, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+	state, err := listClusterState(context.Background(), ifm)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]manager.InventoryEntry, 0)
+	for _, e := range manager.BuildInventory(state, cfg.Modules.EdgeAutoConfig.Adopt) {
+		if o.namespace != "" && e.Namespace != o.namespace {
+			continue
+		}
+		// a service with invalid labels has no ports, it is only listed without a port filter
+		if o.ports != "" && (e.GatewayPort < minPort || e.GatewayPort > maxPort) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	switch o.output {
+	case "json":
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	case "yaml":
+		data, err := yaml.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, string(data))
+	default:
+		printInventory(out, entries)
+	}
+	return nil
+}
+
+// printInventory prints entries as a table
+func printInventory(out io.Writer, entries []manager.InventoryEntry) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tSERVICE\tSERVICE PORT\tGATEWAY PORT\tPROTOCOL\tGATEWAY SELECTOR\tHEALTH")
+	for _, e := range entries {
+		servicePort, gatewayPort, protocol := "-", "-", "-"
+		if e.GatewayPort != 0 {
+			servicePort = strconv.FormatUint(uint64(e.ServicePort), 10)
+			gatewayPort = strconv.FormatUint(uint64(e.GatewayPort), 10)
+			protocol = e.Protocol
+		}
+		selector := labels.Set(e.GatewaySelector).String()
+		if selector == "" {
+			selector = "-"
+		}
+		health := string(e.Health)
+		if e.Error != "" {
+			health += ": " + e.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Namespace, e.Service, servicePort, gatewayPort, protocol, selector, health)
+	}
+	w.Flush()
+}
+
+// parsePortRange parses a port range min-max or a single port, an empty range matches all ports
+func parsePortRange(s string) (uint32, uint32, error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	max := min
+	if len(parts) == 2 {
+		max, err = strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q: %d is greater than %d", s, min, max)
+	}
+	return uint32(min), uint32(max), nil
+}
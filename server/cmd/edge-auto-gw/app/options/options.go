@@ -14,6 +14,7 @@ import (
 
 type EdgeAutoGwOptions struct {
 	ConfigFile string
+	DryRun     bool
 }
 
 func NewEdgeAutoGwOptions() *EdgeAutoGwOptions {
@@ -25,6 +26,7 @@ func NewEdgeAutoGwOptions() *EdgeAutoGwOptions {
 func (o *EdgeAutoGwOptions) Flags() (fss cliflag.NamedFlagSets) {
 	fs := fss.FlagSet("global")
 	fs.StringVar(&o.ConfigFile, "config-file", o.ConfigFile, "The path to the configuration file. Flags override values in this file.")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Render the gateway resources and log the diff to the live ones, without writing anything to the cluster.")
 	return
 }
 
@@ -43,5 +45,8 @@ func (o *EdgeAutoGwOptions) Config() (*config.EdgeAutoGwConfig, error) {
 	if err := cfg.Parse(o.ConfigFile); err != nil {
 		return nil, err
 	}
+	if o.DryRun {
+		cfg.Modules.EdgeAutoConfig.DryRun = true
+	}
 	return cfg, nil
 }
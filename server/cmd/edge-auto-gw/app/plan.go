@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+type planOptions struct {
+	clusterOptions
+}
+
+func newPlanCommand() *cobra.Command {
+	o := &planOptions{}
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show what edge-auto-gw would change in the cluster",
+		Long: `plan connects to the cluster read-only, renders the Gateway, VirtualService and DestinationRule
+of every labeled Service and prints the objects edge-auto-gw would create, update and delete with
+their diffs, the objects in the way of a generated one and the Services with invalid labels.
+The adopt setting of --config-file is honoured.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *planOptions) run(out io.Writer) error {
+	cfg, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+	state, err := listClusterState(context.Background(), ifm)
+	if err != nil {
+		return err
+	}
+
+	plan := manager.BuildPlan(state, cfg.Modules.EdgeAutoConfig.Adopt)
+	printPlan(out, plan)
+	return nil
+}
+
+// printPlan prints plan like terraform plan does
+func printPlan(out io.Writer, plan *manager.Plan) {
+	for _, item := range plan.Items {
+		switch item.Action {
+		case manager.PlanCreate:
+			fmt.Fprintf(out, "+ %s %s/%s will be created\n%s\n\n", item.Kind, item.Namespace, item.Name, indent(item.Diff, "    "))
+		case manager.PlanUpdate:
+			fmt.Fprintf(out, "~ %s %s/%s will be updated (-live +rendered)\n%s\n\n", item.Kind, item.Namespace, item.Name, indent(item.Diff, "  "))
+		case manager.PlanDelete:
+			fmt.Fprintf(out, "- %s %s/%s will be deleted\n\n", item.Kind, item.Namespace, item.Name)
+		case manager.PlanConflict:
+			fmt.Fprintf(out, "! %s %s/%s exists but is not managed by %s, it is left alone unless adopt is enabled\n\n",
+				item.Kind, item.Namespace, item.Name, manager.ManagedByValue)
+		}
+	}
+
+	labelErrors := 0
+	if len(plan.LabelErrors) > 0 {
+		namespaces := make([]string, 0, len(plan.LabelErrors))
+		for ns := range plan.LabelErrors {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+
+		fmt.Fprintf(out, "Services with invalid labels, their resources are left as they are:\n")
+		for _, ns := range namespaces {
+			fmt.Fprintf(out, "  namespace %s:\n", ns)
+			for _, e := range plan.LabelErrors[ns] {
+				fmt.Fprintf(out, "    %s: %s\n", e.Name, e.Error)
+				labelErrors++
+			}
+		}
+		fmt.Fprintln(out)
+	}
+
+	if len(plan.Items) == 0 && labelErrors == 0 {
+		fmt.Fprintf(out, "No changes. The generated resources match the labeled services.\n")
+		return
+	}
+	fmt.Fprintf(out, "Plan: %d to create, %d to update, %d to delete, %d conflicts, %d label errors.\n",
+		plan.Count(manager.PlanCreate), plan.Count(manager.PlanUpdate), plan.Count(manager.PlanDelete),
+		plan.Count(manager.PlanConflict), labelErrors)
+}
+
+// indent prefixes every line of s
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+type renderOptions struct {
+	filename string
+}
+
+func newRenderCommand() *cobra.Command {
+	o := &renderOptions{}
+	cmd := &cobra.Command{
+		Use:   "render [-f FILE]",
+		Short: "Render the gateway resources of Service manifests offline",
+		Long: `render reads Service manifests from a file or stdin, parses their gateway labels and prints the
+Gateway, VirtualService and DestinationRule edge-auto-gw would generate. No cluster is needed,
+documents which are not a Service are skipped. The owner reference and source annotations need the
+uid of the live Service and are left out. It exits non-zero if a Service has invalid labels.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVarP(&o.filename, "filename", "f", "-", "The Service manifest to render, - reads stdin.")
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *renderOptions) run(out, errOut io.Writer) error {
+	in := os.Stdin
+	if o.filename != "-" {
+		f, err := os.Open(o.filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	svcs, err := readServices(in)
+	if err != nil {
+		return err
+	}
+	if len(svcs) == 0 {
+		return fmt.Errorf("no Service found in %s", o.filename)
+	}
+
+	failed := 0
+	for _, svc := range svcs {
+		docs, err := renderService(svc)
+		if err != nil {
+			failed++
+			fmt.Fprintf(errOut, "service %s.%s: %v\n", svc.Namespace, svc.Name, err)
+			continue
+		}
+		for _, doc := range docs {
+			fmt.Fprintf(out, "---\n%s", doc)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services can not be rendered", failed, len(svcs))
+	}
+	return nil
+}
+
+// readServices decodes the Service documents of a YAML or JSON stream
+func readServices(in io.Reader) ([]*v1.Service, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(in), 4096)
+	var svcs []*v1.Service
+	for {
+		svc := &v1.Service{}
+		if err := decoder.Decode(svc); err != nil {
+			if err == io.EOF {
+				return svcs, nil
+			}
+			return nil, fmt.Errorf("decode manifest failed: %v", err)
+		}
+		if svc.Kind != "Service" {
+			continue
+		}
+		if svc.Namespace == "" {
+			svc.Namespace = v1.NamespaceDefault
+		}
+		svcs = append(svcs, svc)
+	}
+}
+
+// renderService returns the YAML documents of the objects generated for svc
+func renderService(svc *v1.Service) ([]string, error) {
+	selector := controller.ServiceSelector()
+	if !selector.Matches(labels.Set(svc.GetLabels())) {
+		return nil, fmt.Errorf("not selected by edge-auto-gw, its labels must match %s", selector)
+	}
+	exposure, err := manager.RenderExposure(svc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway labels: %v", err)
+	}
+
+	gv := istioapi.SchemeGroupVersion.String()
+	exposure.Gateway.APIVersion, exposure.Gateway.Kind = gv, "Gateway"
+	exposure.VirtualService.APIVersion, exposure.VirtualService.Kind = gv, "VirtualService"
+	exposure.DestinationRule.APIVersion, exposure.DestinationRule.Kind = gv, "DestinationRule"
+
+	var docs []string
+	for _, obj := range []interface{}{exposure.Gateway, exposure.VirtualService, exposure.DestinationRule} {
+		doc, err := offlineDocument(obj)
+		if err != nil {
+			return nil, fmt.Errorf("marshal rendered object failed: %v", err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// offlineDocument returns the YAML of a generated object without what only the cluster knows:
+// the manifest has no service uid, so the owner reference and the source annotations are
+// dropped, and the empty creation timestamp and status are left out
+func offlineDocument(obj interface{}) (string, error) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+
+	delete(doc, "status")
+	if meta, ok := doc["metadata"].(map[string]interface{}); ok {
+		delete(meta, "ownerReferences")
+		if meta["creationTimestamp"] == nil {
+			delete(meta, "creationTimestamp")
+		}
+		if annotations, ok := meta["annotations"].(map[string]interface{}); ok {
+			delete(annotations, manager.AnnotationSourceUID)
+			if len(annotations) == 0 {
+				delete(meta, "annotations")
+			}
+		}
+	}
+
+	data, err = yaml.Marshal(doc)
+	return string(data), err
+}
@@ -1,15 +1,20 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/kubeedge/beehive/pkg/core"
+	beehiveContext "github.com/kubeedge/beehive/pkg/core/context"
 	"github.com/kubeedge/kubeedge/pkg/util"
 	"github.com/kubeedge/kubeedge/pkg/util/flag"
 	"github.com/kubeedge/kubeedge/pkg/version"
 	"github.com/kubeedge/kubeedge/pkg/version/verflag"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
-	"k8s.io/apimachinery/pkg/util/wait"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/component-base/cli/globalflag"
 	"k8s.io/component-base/term"
@@ -18,7 +23,10 @@ import (
 	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/config"
 	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/config/validation"
 	"github.com/yz271544/edge-auto-gw/server/cmd/edge-auto-gw/app/options"
+	"github.com/yz271544/edge-auto-gw/server/common/healthz"
 	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/common/leaderelection"
+	"github.com/yz271544/edge-auto-gw/server/common/sharding"
 	"github.com/yz271544/edge-auto-gw/server/pkg/autogw"
 )
 
@@ -67,15 +75,27 @@ func NewEdgeAutoGwServerCommand() *cobra.Command {
 	})
 	cmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(cmd.OutOrStdout(), "%s\n\n"+usageFmt, cmd.Long, cmd.UseLine())
+		printSubcommands(cmd)
 		cliflag.PrintSections(cmd.OutOrStdout(), namedFs, cols)
 	})
+
+	cmd.AddCommand(newRenderCommand())
+	cmd.AddCommand(newPlanCommand())
+	cmd.AddCommand(newListCommand())
+	cmd.AddCommand(newExportCommand())
+	cmd.AddCommand(newImportCommand())
+	cmd.AddCommand(newUninstallCommand())
+	cmd.AddCommand(newDoctorCommand())
 	return cmd
 }
 
-//Run runs EdgeAutoGw Server
+// Run runs EdgeAutoGw Server until SIGINT or SIGTERM
 func Run(cfg *config.EdgeAutoGwConfig) error {
 	trace := 1
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	klog.Infof("[%d] New informers manager", trace)
 	ifm, err := informers.NewManager(cfg.KubeAPIConfig)
 	if err != nil {
@@ -83,23 +103,99 @@ func Run(cfg *config.EdgeAutoGwConfig) error {
 	}
 	trace++
 
+	klog.Infof("[%d] Serve health and metrics endpoints", trace)
+	hz := healthz.NewServer(cfg.CommonConfig.HealthzBindAddress)
+	hz.AddReadyzCheck("informer-sync", func() error {
+		if !ifm.HasSynced() {
+			return fmt.Errorf("caches are not synced yet")
+		}
+		return nil
+	})
+	hz.Handle("/metrics", promhttp.Handler())
+	go hz.Run(ctx)
+	trace++
+
+	klog.Infof("[%d] Run preflight checks", trace)
+	if err = preflight(ctx, cfg, ifm); err != nil {
+		return err
+	}
+	trace++
+
 	klog.Infof("[%d] Register beehive modules", trace)
 	if errs := registerModules(cfg, ifm); len(errs) > 0 {
 		return fmt.Errorf(util.SpliceErrors(errs))
 	}
 	trace++
 
+	// a dry-run instance is trialled next to the running ones, it neither takes
+	// the leader lease nor a shard away from them and renders every namespace
+	dryRun := cfg.Modules.EdgeAutoConfig.DryRun
+	if dryRun {
+		klog.Infof("dry-run: nothing is written to the cluster, leader election and sharding are skipped")
+	}
+
+	// the shard is known before the informers queue the existing services, it is
+	// left once the modules stopped, so no other instance takes over a namespace
+	// while a reconcile of it is still in flight here
+	if sc := cfg.CommonConfig.Sharding; sc.Enable && !dryRun {
+		klog.Infof("[%d] Join namespace shards", trace)
+		leave, err := sharding.Start(sharding.Config{
+			LeaseNamespace: sc.LeaseNamespace,
+			LeaseDuration:  sc.LeaseDuration.Duration,
+			RenewPeriod:    sc.RenewPeriod.Duration,
+		}, ifm.GetKubeClient())
+		if err != nil {
+			return err
+		}
+		defer leave()
+		trace++
+	}
+
 	klog.Infof("[%d] Start informers manager", trace)
-	ifm.Start(wait.NeverStop)
+	if err = ifm.Start(ctx.Done()); err != nil {
+		klog.Infof("edge-auto-gw exited before caches synced: %v", err)
+		return nil
+	}
 	trace++
 
+	if le := cfg.CommonConfig.LeaderElection; le.LeaderElect && !dryRun {
+		klog.Infof("[%d] Start leader election", trace)
+		err = leaderelection.Run(ctx, le, ifm.GetKubeClient(), func(ctx context.Context) {
+			klog.Infof("[%d] Start all modules", trace+1)
+			runModules(ctx, cfg.CommonConfig.ShutdownGracePeriod.Duration)
+		})
+		if err != nil {
+			return err
+		}
+		klog.Infof("edge-auto-gw exited")
+		return nil
+	}
+
 	klog.Infof("[%d] Start all modules", trace)
-	core.Run()
+	runModules(ctx, cfg.CommonConfig.ShutdownGracePeriod.Duration)
 
 	klog.Infof("edge-auto-gw exited")
 	return nil
 }
 
+// runModules starts all the beehive modules and shuts them down once ctx is done,
+// the modules get gracePeriod to finish their work in flight
+func runModules(ctx context.Context, gracePeriod time.Duration) {
+	core.StartModules()
+	<-ctx.Done()
+
+	klog.Infof("shutting down modules, waiting up to %v", gracePeriod)
+	beehiveContext.Cancel()
+	select {
+	case <-autogw.Stopped():
+	case <-time.After(gracePeriod):
+		klog.Warningf("modules did not stop within %v, exiting anyway", gracePeriod)
+	}
+	for name := range core.GetModules() {
+		beehiveContext.Cleanup(name)
+	}
+}
+
 // registerModules register all the modules started in edge-auto-gw
 func registerModules(c *config.EdgeAutoGwConfig, ifm *informers.Manager) []error {
 	var errs []error
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+type uninstallOptions struct {
+	clusterOptions
+	yes         bool
+	stripLabels bool
+}
+
+// managedObject is a generated object uninstall deletes
+type managedObject struct {
+	kind      string
+	namespace string
+	name      string
+	uid       types.UID
+}
+
+func newUninstallCommand() *cobra.Command {
+	o := &uninstallOptions{}
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Withdraw every edge exposure from the cluster",
+		Long: `uninstall deletes every Gateway, VirtualService and DestinationRule managed by edge-auto-gw and
+removes the cleanup finalizer and the status annotation from the Services, optionally with their
+gateway labels, so no port stays open on the edge gateways. Delete the edge-auto-gw Deployment
+first, a running instance generates the resources again. It asks for confirmation unless --yes
+is given and prints a summary of what was removed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+		SilenceUsage: true,
+	}
+	o.addFlags(cmd.Flags())
+	cmd.Flags().BoolVarP(&o.yes, "yes", "y", false, "Remove without asking for confirmation.")
+	cmd.Flags().BoolVar(&o.stripLabels, "strip-labels", false, "Also remove the gateway labels from the Services.")
+	setSubcommandUsage(cmd)
+	return cmd
+}
+
+func (o *uninstallOptions) run(in io.Reader, out io.Writer) error {
+	_, ifm, err := o.clients()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	state, err := listClusterState(ctx, ifm)
+	if err != nil {
+		return err
+	}
+	objects := managedObjects(state)
+
+	// released services may have lost their gateway labels already, so all services are read
+	svcs, err := ifm.GetKubeClient().CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list services failed: %v", err)
+	}
+	var held []*v1.Service
+	for i := range svcs.Items {
+		if _, ok, _ := manager.UninstallPatch(&svcs.Items[i], o.stripLabels); ok {
+			held = append(held, &svcs.Items[i])
+		}
+	}
+
+	if len(objects) == 0 && len(held) == 0 {
+		fmt.Fprintf(out, "Nothing to remove, no resources are managed by %s.\n", manager.ManagedByValue)
+		return nil
+	}
+	for _, obj := range objects {
+		fmt.Fprintf(out, "- %s %s/%s\n", obj.kind, obj.namespace, obj.name)
+	}
+	for _, svc := range held {
+		fmt.Fprintf(out, "~ Service %s/%s\n", svc.Namespace, svc.Name)
+	}
+	if !o.yes && !confirm(in, out, fmt.Sprintf("Delete %d resources and release %d services?", len(objects), len(held))) {
+		return fmt.Errorf("uninstall aborted")
+	}
+
+	deleted := make(map[string]int)
+	for _, obj := range objects {
+		ok, err := deleteManagedObject(ctx, ifm, obj)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(out, "skip %s %s/%s, it is gone or was recreated\n", obj.kind, obj.namespace, obj.name)
+			continue
+		}
+		deleted[obj.kind]++
+	}
+	for _, svc := range held {
+		if err := releaseService(ctx, ifm, svc, o.stripLabels); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(out, "Removed %d gateways, %d virtualservices and %d destinationrules, released %d services",
+		deleted["Gateway"], deleted["VirtualService"], deleted["DestinationRule"], len(held))
+	if o.stripLabels {
+		fmt.Fprintf(out, " and stripped their gateway labels")
+	}
+	fmt.Fprintln(out, ".")
+	return nil
+}
+
+// managedObjects returns the generated objects of state, gateways first to close the ports
+// on edgemesh-gateway before their routes go away, like the controller deletes them
+func managedObjects(state *manager.ClusterState) []managedObject {
+	var objects []managedObject
+	for _, gw := range state.Gateways {
+		if manager.IsManaged(gw) {
+			objects = append(objects, managedObject{kind: "Gateway", namespace: gw.Namespace, name: gw.Name, uid: gw.UID})
+		}
+	}
+	for _, vs := range state.VirtualServices {
+		if manager.IsManaged(vs) {
+			objects = append(objects, managedObject{kind: "VirtualService", namespace: vs.Namespace, name: vs.Name, uid: vs.UID})
+		}
+	}
+	for _, dr := range state.DestinationRules {
+		if manager.IsManaged(dr) {
+			objects = append(objects, managedObject{kind: "DestinationRule", namespace: dr.Namespace, name: dr.Name, uid: dr.UID})
+		}
+	}
+	return objects
+}
+
+// confirm asks question and reports whether it was answered with yes
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// deleteManagedObject deletes the listed object, it reports false if the object is gone or was
+// recreated since it was listed, the uid precondition leaves a recreated object alone
+func deleteManagedObject(ctx context.Context, ifm *informers.Manager, obj managedObject) (bool, error) {
+	networking := ifm.GetIstioClient().NetworkingV1alpha3()
+	opts := metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(obj.uid))}
+	var err error
+	switch obj.kind {
+	case "Gateway":
+		err = networking.Gateways(obj.namespace).Delete(ctx, obj.name, opts)
+	case "VirtualService":
+		err = networking.VirtualServices(obj.namespace).Delete(ctx, obj.name, opts)
+	case "DestinationRule":
+		err = networking.DestinationRules(obj.namespace).Delete(ctx, obj.name, opts)
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case apierrors.IsNotFound(err) || apierrors.IsConflict(err):
+		return false, nil
+	default:
+		return false, fmt.Errorf("delete %s %s/%s failed: %v", obj.kind, obj.namespace, obj.name, err)
+	}
+}
+
+// releaseService removes the finalizer, the status annotation and with stripLabels the gateway labels of svc
+func releaseService(ctx context.Context, ifm *informers.Manager, svc *v1.Service, stripLabels bool) error {
+	data, ok, err := manager.UninstallPatch(svc, stripLabels)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = ifm.GetKubeClient().CoreV1().Services(svc.Namespace).Patch(ctx, svc.Name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("release service %s/%s failed: %v", svc.Namespace, svc.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package healthz
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/yz271544/edge-auto-gw/server/common/leaderelection"
+)
+
+// shutdownTimeout bounds how long open requests may take once the server stops
+const shutdownTimeout = 5 * time.Second
+
+// Check reports why the instance is not ready, nil when it is
+type Check func() error
+
+// Server serves the liveness endpoint /healthz and the readiness endpoint /readyz
+type Server struct {
+	lock   sync.RWMutex
+	checks map[string]Check
+	mux    *http.ServeMux
+	addr   string
+}
+
+// NewServer returns a health server listening on addr
+func NewServer(addr string) *Server {
+	s := &Server{
+		checks: make(map[string]Check),
+		mux:    http.NewServeMux(),
+		addr:   addr,
+	}
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+	return s
+}
+
+// AddReadyzCheck adds a check which must pass before /readyz reports ready
+func (s *Server) AddReadyzCheck(name string, check Check) {
+	s.lock.Lock()
+	s.checks[name] = check
+	s.lock.Unlock()
+}
+
+// Handle registers handler for path next to the health endpoints
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.mux.Handle(path, handler)
+}
+
+// Run serves until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	srv := &http.Server{Addr: s.addr, Handler: s.mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			klog.Errorf("shutdown health server failed: %v", err)
+		}
+	}()
+
+	klog.Infof("serving health endpoints on %s", s.addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		klog.Errorf("health server failed: %v", err)
+	}
+}
+
+// healthz reports the process is alive
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+// readyz runs every readiness check, the instance is ready when all of them pass
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	names := make([]string, 0, len(s.checks))
+	checks := make(map[string]Check, len(s.checks))
+	for name, check := range s.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	s.lock.RUnlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	ready := true
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			ready = false
+			fmt.Fprintf(&b, "[-]%s failed: %v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(&b, "[+]%s ok\n", name)
+	}
+	if leader := leaderelection.Leader(); leader != "" {
+		fmt.Fprintf(&b, "leader: %s\n", leader)
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, b.String())
+		return
+	}
+	fmt.Fprint(w, b.String())
+}
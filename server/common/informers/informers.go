@@ -3,6 +3,7 @@ package informers
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/kubeedge/kubeedge/pkg/apis/componentconfig/cloudcore/v1alpha1"
 	istio "istio.io/client-go/pkg/clientset/versioned"
@@ -28,6 +29,8 @@ type Manager struct {
 	lock        sync.Mutex
 	informers   map[string]cache.SharedIndexInformer // key is informer instance address
 	syncedFuncs []syncedFunc
+	// synced is set to 1 once all caches are synchronized
+	synced int32
 }
 
 func NewManager(config *v1alpha1.KubeAPIConfig) (*Manager, error) {
@@ -44,14 +47,18 @@ func NewManager(config *v1alpha1.KubeAPIConfig) (*Manager, error) {
 	istioKubeConfig.ContentType = runtime.ContentTypeJSON
 	istioClient := istio.NewForConfigOrDie(istioKubeConfig)
 
-	mgr := Manager{
+	return NewManagerForClients(kubeClient, istioClient), nil
+}
+
+// NewManagerForClients returns a Manager whose factories use the given clients
+func NewManagerForClients(kubeClient kubernetes.Interface, istioClient istio.Interface) *Manager {
+	return &Manager{
 		kubeClient:   kubeClient,
 		istioClient:  istioClient,
 		kubeFactory:  k8sinformers.NewSharedInformerFactory(kubeClient, 0),
 		istioFactory: istioinformers.NewSharedInformerFactory(istioClient, 0),
 		informers:    make(map[string]cache.SharedIndexInformer),
 	}
-	return &mgr, nil
 }
 
 // RegisterInformer add a informer to Manager. It is important to note that
@@ -76,8 +83,9 @@ func (mgr *Manager) RegisterSyncedFunc(fn syncedFunc) {
 	mgr.lock.Unlock()
 }
 
-// Start starts all factories and run all informers
-func (mgr *Manager) Start(stopCh <-chan struct{}) {
+// Start starts all factories and run all informers, it returns once all caches are synchronized.
+// An error is returned when stopCh is closed before that.
+func (mgr *Manager) Start(stopCh <-chan struct{}) error {
 	mgr.lock.Lock()
 	defer mgr.lock.Unlock()
 
@@ -89,21 +97,33 @@ func (mgr *Manager) Start(stopCh <-chan struct{}) {
 	mgr.istioFactory.Start(stopCh)
 
 	// sync cache
+	for _, informer := range mgr.informers {
+		if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+			return fmt.Errorf("stopped waiting for informer caches to sync")
+		}
+	}
 	for _, ok := range mgr.kubeFactory.WaitForCacheSync(stopCh) {
 		if !ok {
-			klog.Fatalf("timed out waiting for kubernetes caches to sync")
+			return fmt.Errorf("stopped waiting for kubernetes caches to sync")
 		}
 	}
 	for _, ok := range mgr.istioFactory.WaitForCacheSync(stopCh) {
 		if !ok {
-			klog.Fatalf("timed out waiting for istio caches to sync")
+			return fmt.Errorf("stopped waiting for istio caches to sync")
 		}
 	}
+	atomic.StoreInt32(&mgr.synced, 1)
 
 	// when caches are synchronized, all syncedFunc needs to be called
 	for _, fn := range mgr.syncedFuncs {
 		fn()
 	}
+	return nil
+}
+
+// HasSynced reports whether all caches are synchronized
+func (mgr *Manager) HasSynced() bool {
+	return atomic.LoadInt32(&mgr.synced) == 1
 }
 
 func (mgr *Manager) GetKubeClient() kubernetes.Interface {
@@ -0,0 +1,109 @@
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	componentbaseconfig "k8s.io/component-base/config"
+	"k8s.io/klog/v2"
+)
+
+var (
+	lock     sync.RWMutex
+	identity string
+	leader   string
+)
+
+// Identity returns the identity this instance campaigns with
+func Identity() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return identity
+}
+
+// Leader returns the identity of the current leader, empty while it is unknown
+func Leader() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return leader
+}
+
+// IsLeader reports whether this instance is the current leader
+func IsLeader() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return identity != "" && identity == leader
+}
+
+// Run campaigns for the lease described by cfg and calls run once this instance is elected.
+// Until then the instance is a standby, its informer caches stay warm so it can take over
+// within a lease duration. Losing the lease ends the process, a restarted instance joins
+// as a standby again. Cancelling ctx ends run and gives up the lease, so a standby takes
+// over right away.
+func Run(ctx context.Context, cfg *componentbaseconfig.LeaderElectionConfiguration, client kubernetes.Interface, run func(ctx context.Context)) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("get hostname failed: %v", err)
+	}
+	id := hostname + "_" + string(uuid.NewUUID())
+	lock.Lock()
+	identity = id
+	lock.Unlock()
+
+	rl, err := resourcelock.New(cfg.ResourceLock,
+		cfg.ResourceNamespace,
+		cfg.ResourceName,
+		client.CoreV1(),
+		client.CoordinationV1(),
+		resourcelock.ResourceLockConfig{
+			Identity: id,
+		})
+	if err != nil {
+		return fmt.Errorf("create resource lock failed: %v", err)
+	}
+
+	// cancelled once run returns, which ends the campaign without giving up the process
+	campaignCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	klog.Infof("campaign for lease %s/%s as %s", cfg.ResourceNamespace, cfg.ResourceName, id)
+	leaderelection.RunOrDie(campaignCtx, leaderelection.LeaderElectionConfig{
+		Lock:          rl,
+		LeaseDuration: cfg.LeaseDuration.Duration,
+		RenewDeadline: cfg.RenewDeadline.Duration,
+		RetryPeriod:   cfg.RetryPeriod.Duration,
+		// run has returned by the time the lease is released
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("%s became the leader", id)
+				run(ctx)
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-campaignCtx.Done():
+					klog.Infof("%s stopped leading", id)
+				default:
+					klog.Fatalf("%s lost the leader lease, exiting", id)
+				}
+			},
+			OnNewLeader: func(current string) {
+				lock.Lock()
+				leader = current
+				lock.Unlock()
+				if current != id {
+					klog.Infof("the leader is %s, %s is standing by", current, id)
+				}
+			},
+		},
+		Name: cfg.ResourceName,
+	})
+	return nil
+}
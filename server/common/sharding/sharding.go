@@ -0,0 +1,309 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// LabelShardMember marks the leases holding the membership of the edge-auto-gw shards
+	LabelShardMember = "kubeedge.io/edge-auto-gw-shard-member"
+
+	leaseNamePrefix = "edge-auto-gw-shard-"
+	// virtualNodes is the number of points every member owns on the hash ring,
+	// more points spread the namespaces more evenly
+	virtualNodes = 100
+)
+
+var (
+	lock     sync.RWMutex
+	enabled  bool
+	identity string
+	members  []string
+	ring     []ringPoint
+	// validUntil is when the lease of this instance expires, the other members take over its
+	// namespaces from then on, so it must stop reconciling them even if it can not tell
+	validUntil    time.Time
+	rebalanceFunc []func()
+)
+
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// hashOf places s on the hash ring. FNV alone clusters keys which only differ in their last
+// bytes, like the virtual nodes of a member, so the hash is finalized like murmur3 does.
+func hashOf(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// buildRing places virtualNodes points of every member on the hash ring
+func buildRing(members []string) []ringPoint {
+	points := make([]ringPoint, 0, len(members)*virtualNodes)
+	for _, m := range members {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, ringPoint{hash: hashOf(m + "#" + strconv.Itoa(i)), member: m})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	return points
+}
+
+// ownerOf returns the member owning namespace, the caller holds the lock
+func ownerOf(namespace string) string {
+	return ownerIn(ring, namespace)
+}
+
+// ownerIn returns the member of ring owning namespace, empty if the ring has no members
+func ownerIn(ring []ringPoint, namespace string) string {
+	if len(ring) == 0 {
+		return ""
+	}
+	h := hashOf(namespace)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].member
+}
+
+// Owns reports whether namespace belongs to the shard of this instance.
+// Without sharding every namespace belongs to this instance, with an expired
+// lease none does.
+func Owns(namespace string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	if !enabled {
+		return true
+	}
+	if !time.Now().Before(validUntil) {
+		return false
+	}
+	return ownerOf(namespace) == identity
+}
+
+// Members returns the identities of the live shard members
+func Members() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return append([]string(nil), members...)
+}
+
+// RegisterRebalanceFunc adds a func which is called whenever the namespaces are
+// split differently, because an instance joined or left
+func RegisterRebalanceFunc(fn func()) {
+	lock.Lock()
+	rebalanceFunc = append(rebalanceFunc, fn)
+	lock.Unlock()
+}
+
+// Config indicates how the membership of the shards is kept
+type Config struct {
+	// LeaseNamespace is the namespace of the membership leases
+	LeaseNamespace string
+	// LeaseDuration is how long a member is considered alive after its last renewal
+	LeaseDuration time.Duration
+	// RenewPeriod is how often a member renews its lease and reads the others
+	RenewPeriod time.Duration
+}
+
+// Start joins this instance to the shards and splits the namespaces between the live members by
+// consistent hashing, so only a small part of the namespaces moves when an instance joins or leaves.
+// Every member renews a lease of its own, members whose lease expired are dropped. The initial
+// membership is read before Start returns, then it is kept up to date until the returned leave
+// func is called. Call it once the workers stopped, the namespaces of this instance are not
+// handed to the others while its reconciles are still in flight.
+func Start(cfg Config, client kubernetes.Interface) (leave func(), err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("get hostname failed: %v", err)
+	}
+
+	lock.Lock()
+	enabled = true
+	identity = hostname
+	lock.Unlock()
+
+	m := &membership{cfg: cfg, client: client, identity: hostname}
+	if err := m.sync(); err != nil {
+		return nil, fmt.Errorf("join shards failed: %v", err)
+	}
+	stopCh, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		wait.Until(func() {
+			if err := m.sync(); err != nil {
+				klog.Errorf("sync shard membership failed: %v", err)
+			}
+		}, cfg.RenewPeriod, stopCh)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// a sync in flight must not renew the lease after it was deleted
+			close(stopCh)
+			<-done
+			m.leave()
+		})
+	}, nil
+}
+
+type membership struct {
+	cfg      Config
+	client   kubernetes.Interface
+	identity string
+}
+
+func (m *membership) leaseName() string {
+	return leaseNamePrefix + strings.ToLower(m.identity)
+}
+
+// sync renews the lease of this instance and rebuilds the ring from the live members.
+// An instance which can not renew its lease keeps its shard until the lease expires,
+// then it drops every namespace until it renews again.
+func (m *membership) sync() error {
+	renewed := time.Now()
+	if err := m.renew(); err != nil {
+		lock.Lock()
+		expired := len(members) > 0 && !time.Now().Before(validUntil)
+		if expired {
+			members, ring = nil, nil
+		}
+		lock.Unlock()
+		if expired {
+			klog.Errorf("shard lease of %s expired, it drops all namespaces until it renews", m.identity)
+		}
+		return err
+	}
+	lock.Lock()
+	validUntil = renewed.Add(m.cfg.LeaseDuration)
+	lock.Unlock()
+
+	leases, err := m.client.CoordinationV1().Leases(m.cfg.LeaseNamespace).List(context.Background(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{LabelShardMember: "true"}).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("list shard leases failed: %v", err)
+	}
+
+	now := time.Now()
+	live := []string{m.identity}
+	for _, lease := range leases.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || *spec.HolderIdentity == m.identity || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expire := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expire) {
+			live = append(live, *spec.HolderIdentity)
+			continue
+		}
+		m.collect(&lease)
+	}
+	sort.Strings(live)
+
+	lock.Lock()
+	changed := strings.Join(live, ",") != strings.Join(members, ",")
+	if changed {
+		members = live
+		ring = buildRing(live)
+	}
+	fns := rebalanceFunc
+	lock.Unlock()
+
+	if changed {
+		klog.Infof("shard members changed to %v, %s rebalances its namespaces", live, m.identity)
+		for _, fn := range fns {
+			fn()
+		}
+	}
+	return nil
+}
+
+// renew creates or renews the lease of this instance
+func (m *membership) renew() error {
+	client := m.client.CoordinationV1().Leases(m.cfg.LeaseNamespace)
+	now := metav1.NewMicroTime(time.Now())
+	duration := int32(m.cfg.LeaseDuration / time.Second)
+
+	lease, err := client.Get(context.Background(), m.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.cfg.LeaseNamespace,
+				Labels:    map[string]string{LabelShardMember: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = client.Create(context.Background(), lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("get shard lease failed: %v", err)
+	}
+
+	lease.Spec.HolderIdentity = &m.identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = client.Update(context.Background(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// collect deletes the expired lease of a member which did not leave cleanly, the preconditions
+// keep a lease which was renewed in the meantime
+func (m *membership) collect(lease *coordinationv1.Lease) {
+	err := m.client.CoordinationV1().Leases(lease.Namespace).Delete(context.Background(), lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		klog.Warningf("delete expired shard lease %s failed: %v", lease.Name, err)
+		return
+	}
+	klog.V(4).Infof("deleted expired shard lease %s", lease.Name)
+}
+
+// leave drops the namespaces of this instance and deletes its lease, so the others take
+// them over right away
+func (m *membership) leave() {
+	lock.Lock()
+	validUntil = time.Time{}
+	lock.Unlock()
+
+	err := m.client.CoordinationV1().Leases(m.cfg.LeaseNamespace).Delete(context.Background(), m.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Errorf("leave shards failed: %v", err)
+		return
+	}
+	klog.Infof("%s left the shards", m.identity)
+}
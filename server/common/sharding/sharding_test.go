@@ -0,0 +1,234 @@
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func namespaces(n int) []string {
+	ns := make([]string, n)
+	for i := range ns {
+		ns[i] = fmt.Sprintf("tenant-%d", i)
+	}
+	return ns
+}
+
+func TestOwnerInStable(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		other   []string
+	}{
+		{name: "single member", members: []string{"a"}, other: []string{"a"}},
+		{name: "member order", members: []string{"a", "b", "c"}, other: []string{"c", "a", "b"}},
+		{name: "many members", members: []string{"a", "b", "c", "d", "e"}, other: []string{"e", "d", "c", "b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, other := buildRing(tt.members), buildRing(tt.other)
+			for _, ns := range namespaces(500) {
+				owner := ownerIn(ring, ns)
+				if owner == "" {
+					t.Fatalf("namespace %s has no owner", ns)
+				}
+				if again := ownerIn(ring, ns); again != owner {
+					t.Fatalf("namespace %s owned by %s, then by %s", ns, owner, again)
+				}
+				if got := ownerIn(other, ns); got != owner {
+					t.Fatalf("namespace %s owned by %s with members %v and by %s with %v", ns, owner, tt.members, got, tt.other)
+				}
+			}
+		})
+	}
+
+	if got := ownerIn(nil, "default"); got != "" {
+		t.Errorf("empty ring owns default by %q, want no owner", got)
+	}
+}
+
+func TestOwnerInMovement(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		// changed is the member which joined or left, only its namespaces move
+		changed string
+		// maxMoved is the largest share of the namespaces which may move
+		maxMoved float64
+	}{
+		{name: "join second", before: []string{"a"}, after: []string{"a", "b"}, changed: "b", maxMoved: 0.65},
+		{name: "join fourth", before: []string{"a", "b", "c"}, after: []string{"a", "b", "c", "d"}, changed: "d", maxMoved: 0.35},
+		{name: "leave of four", before: []string{"a", "b", "c", "d"}, after: []string{"a", "b", "d"}, changed: "c", maxMoved: 0.35},
+		{name: "leave of two", before: []string{"a", "b"}, after: []string{"b"}, changed: "a", maxMoved: 0.65},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := buildRing(tt.before), buildRing(tt.after)
+			all := namespaces(2000)
+			moved := 0
+			for _, ns := range all {
+				from, to := ownerIn(before, ns), ownerIn(after, ns)
+				if from == to {
+					continue
+				}
+				moved++
+				if from != tt.changed && to != tt.changed {
+					t.Errorf("namespace %s moved from %s to %s, only namespaces of %s may move", ns, from, to, tt.changed)
+				}
+			}
+			if share := float64(moved) / float64(len(all)); moved == 0 || share > tt.maxMoved {
+				t.Errorf("%d of %d namespaces moved, want some but at most %.0f%%", moved, len(all), tt.maxMoved*100)
+			}
+		})
+	}
+}
+
+// resetState restores the package state changed by a test
+func resetState(t *testing.T) {
+	t.Cleanup(func() {
+		lock.Lock()
+		enabled, identity, members, ring, validUntil, rebalanceFunc = false, "", nil, nil, time.Time{}, nil
+		lock.Unlock()
+	})
+}
+
+func memberLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	duration := int32(30)
+	renew := metav1.NewMicroTime(renewed)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      leaseNamePrefix + holder,
+			Namespace: "kubeedge",
+			Labels:    map[string]string{LabelShardMember: "true"},
+		},
+		Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder, LeaseDurationSeconds: &duration, RenewTime: &renew},
+	}
+}
+
+func TestSync(t *testing.T) {
+	cfg := Config{LeaseNamespace: "kubeedge", LeaseDuration: 30 * time.Second, RenewPeriod: 10 * time.Second}
+
+	t.Run("live members and expired leases", func(t *testing.T) {
+		resetState(t)
+		client := fake.NewSimpleClientset(memberLease("b", time.Now()), memberLease("c", time.Now().Add(-time.Hour)))
+		enabled, identity = true, "a"
+
+		m := &membership{cfg: cfg, client: client, identity: "a"}
+		if err := m.sync(); err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+		if got := fmt.Sprint(Members()); got != "[a b]" {
+			t.Errorf("members are %s, want [a b]", got)
+		}
+		if _, err := client.CoordinationV1().Leases("kubeedge").Get(context.Background(), leaseNamePrefix+"c", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expired lease of c was not deleted: %v", err)
+		}
+		owned := 0
+		for _, ns := range namespaces(100) {
+			if Owns(ns) {
+				owned++
+			}
+		}
+		if owned == 0 || owned == 100 {
+			t.Errorf("a owns %d of 100 namespaces, want a share", owned)
+		}
+	})
+
+	tests := []struct {
+		name string
+		// validFor is how long the lease of this instance is still valid when renewing fails
+		validFor  time.Duration
+		wantOwner bool
+	}{
+		{name: "renew fails while the lease is valid", validFor: time.Minute, wantOwner: true},
+		{name: "renew fails after the lease expired", validFor: -time.Second, wantOwner: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetState(t)
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("*", "leases", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("api server unreachable")
+			})
+			enabled, identity, members, ring = true, "a", []string{"a"}, buildRing([]string{"a"})
+			validUntil = time.Now().Add(tt.validFor)
+
+			m := &membership{cfg: cfg, client: client, identity: "a"}
+			if err := m.sync(); err == nil {
+				t.Fatalf("sync succeeded without a lease")
+			}
+			if got := Owns("default"); got != tt.wantOwner {
+				t.Errorf("Owns(default) = %v, want %v", got, tt.wantOwner)
+			}
+			if got := len(Members()) > 0; got != tt.wantOwner {
+				t.Errorf("members are %v, want them kept %v", Members(), tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestOwnerInBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{name: "three members", members: []string{"a", "b", "c"}},
+		{name: "four members", members: []string{"a", "b", "c", "d"}},
+		{name: "pod names", members: []string{"edge-auto-gw-7d9f-x1", "edge-auto-gw-7d9f-x2", "edge-auto-gw-7d9f-x3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := buildRing(tt.members)
+			all := namespaces(4000)
+			owned := make(map[string]int)
+			for _, ns := range all {
+				owned[ownerIn(ring, ns)]++
+			}
+			fair := len(all) / len(tt.members)
+			for _, m := range tt.members {
+				if owned[m] < fair/2 || owned[m] > fair*3/2 {
+					t.Errorf("%s owns %d of %d namespaces, want about %d", m, owned[m], len(all), fair)
+				}
+			}
+		})
+	}
+}
+
+func TestStartAndLeave(t *testing.T) {
+	resetState(t)
+	client := fake.NewSimpleClientset()
+	cfg := Config{LeaseNamespace: "kubeedge", LeaseDuration: 30 * time.Second, RenewPeriod: time.Hour}
+
+	leave, err := Start(cfg, client)
+	if err != nil {
+		t.Fatalf("start failed: %v", err)
+	}
+	leases, err := client.CoordinationV1().Leases("kubeedge").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(leases.Items) != 1 {
+		t.Fatalf("%d leases after joining the shards, want 1: %v", len(leases.Items), err)
+	}
+	// the namespaces stay owned until leave is called, when the workers stopped
+	if !Owns("default") {
+		t.Errorf("the only member does not own default")
+	}
+
+	leave()
+	if Owns("default") {
+		t.Errorf("default is still owned after leaving the shards")
+	}
+	leases, err = client.CoordinationV1().Leases("kubeedge").List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(leases.Items) != 0 {
+		t.Errorf("%d leases after leaving the shards, want none: %v", len(leases.Items), err)
+	}
+	leave()
+}
@@ -1,13 +1,17 @@
 package autogw
 
 import (
-	"time"
+	beehiveContext "github.com/kubeedge/beehive/pkg/core/context"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
 )
 
 func (t *EdgeAutoGw) Run() {
-	for {
-		// klog.Info("auto heartbeat time")
-		// heartbeat time
-		time.Sleep(10 * time.Second)
-	}
+	defer close(stopped)
+
+	// clean up gateway resources whose service went away while nobody was watching
+	go t.mgr.RunOrphanSweep(t.Config.OrphanSweepPeriod.Duration, beehiveContext.Done())
+
+	// process the queued services until beehive is shut down
+	controller.APIConn.Run(int(t.Config.Workers), beehiveContext.Done())
 }
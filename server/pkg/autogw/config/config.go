@@ -1,14 +1,43 @@
 package config
 
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // EdgeAutoGwConfig indicates the edge gateway auto config
 type EdgeAutoGwConfig struct {
 	// Enable indicates whether enable edge auto gateway
 	// default true
 	Enable bool `json:"enable,omitempty"`
+	// Workers indicates how many services are reconciled concurrently,
+	// changes of the same service are always reconciled in order
+	// default 5
+	Workers int32 `json:"workers,omitempty"`
+	// OrphanSweepPeriod indicates how often the managed gateways, virtualservices and
+	// destinationrules are checked for a missing source service, 0 sweeps at startup only
+	// default 10m
+	OrphanSweepPeriod metav1.Duration `json:"orphanSweepPeriod,omitempty"`
+	// Adopt indicates whether pre-existing gateways, virtualservices and destinationrules
+	// named after a labeled service, but not created by edge-auto-gw, are taken over
+	// instead of being reported as a conflict
+	// default false
+	Adopt bool `json:"adopt,omitempty"`
+	// FailOnConflicts indicates whether fields of the generated objects which another field
+	// manager took over fail the reconcile, otherwise they are forced back and reported
+	// default false
+	FailOnConflicts bool `json:"failOnConflicts,omitempty"`
+	// DryRun indicates whether the generated objects are only rendered and the diff to the
+	// live objects logged, nothing is written to the cluster
+	// default false
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
 func NewEdgeAutoGwConfig() *EdgeAutoGwConfig {
 	return &EdgeAutoGwConfig{
-		Enable: true,
+		Enable:            true,
+		Workers:           5,
+		OrphanSweepPeriod: metav1.Duration{Duration: 10 * time.Minute},
 	}
 }
@@ -1,18 +1,27 @@
 package controller
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	k8sinformers "k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 
 	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/common/sharding"
 	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/config"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
 )
 
 const (
@@ -21,6 +30,10 @@ const (
 
 	LabelEdgemeshGatewayProtocols = "kubeedge.io/edgemesh-gateway-protocols"
 	LabelEdgemeshGatewayPort      = "kubeedge.io/edgemesh-gateway-ports"
+
+	// maxRetries is the number of times a service key will be retried before it is dropped out of the queue.
+	// With the default rate limiter the last retry happens roughly 82 seconds after the first failure.
+	maxRetries = 15
 )
 
 var (
@@ -28,34 +41,48 @@ var (
 	once    sync.Once
 )
 
+// ReconcileFunc brings the gateway resources of the service identified by key
+// (namespace/name) in line with the current state of that service
+type ReconcileFunc func(key string) error
+
 type AutoGatewayController struct {
 	sync.RWMutex
-	atInformer      cache.SharedIndexInformer
-	atEventHandlers map[string]cache.ResourceEventHandlerFuncs // key: gateway event handler name
+	atInformer cache.SharedIndexInformer
+	atLister   corelisters.ServiceLister
+	queue      workqueue.RateLimitingInterface
+	reconcile  ReconcileFunc
+	// ignoredAnnotations are written by edge-auto-gw itself, changing only them does not
+	// queue the service again
+	ignoredAnnotations []string
 }
 
-func Init(ifm *informers.Manager, cfg *config.EdgeAutoGwConfig) {
-	once.Do(func() {
+// ServiceSelector selects the services edge-auto-gw exposes: services carrying the gateway
+// protocols label which are not excluded from edgemesh
+func ServiceSelector() labels.Selector {
+	noProxyName, err := labels.NewRequirement(labelNoProxyEdgeMesh, selection.DoesNotExist, nil)
+	if err != nil {
+		klog.Errorf("set selector label %s for request failed: %v", labelNoProxyEdgeMesh, err)
+	}
 
-		configSyncPeriod := metav1.Duration{Duration: 15 * time.Minute}
+	noEdgeMeshProxyName, err := labels.NewRequirement(labelEdgeMeshServiceProxyName, selection.DoesNotExist, nil)
+	if err != nil {
+		klog.Errorf("set selector label %s for request failed: %v", labelEdgeMeshServiceProxyName, err)
+	}
 
-		noProxyName, err := labels.NewRequirement(labelNoProxyEdgeMesh, selection.DoesNotExist, nil)
-		if err != nil {
-			klog.Errorf("set selector label %s for request failed: %v", labelNoProxyEdgeMesh, err)
-		}
+	hasGateway, err := labels.NewRequirement(LabelEdgemeshGatewayProtocols, selection.Exists, nil)
+	if err != nil {
+		klog.Errorf("set selector label %s for request failed: %v", LabelEdgemeshGatewayProtocols, err)
+	}
 
-		noEdgeMeshProxyName, err := labels.NewRequirement(labelEdgeMeshServiceProxyName, selection.DoesNotExist, nil)
-		if err != nil {
-			klog.Errorf("set selector label %s for request failed: %v", labelEdgeMeshServiceProxyName, err)
-		}
+	return labels.NewSelector().Add(*noProxyName, *noEdgeMeshProxyName, *hasGateway)
+}
 
-		hasGateway, err := labels.NewRequirement(LabelEdgemeshGatewayProtocols, selection.Exists, nil)
-		if err != nil {
-			klog.Errorf("set selector label %s for request failed: %v", LabelEdgemeshGatewayProtocols, err)
-		}
+func Init(ifm *informers.Manager, cfg *config.EdgeAutoGwConfig) {
+	once.Do(func() {
 
-		labelSelector := labels.NewSelector()
-		labelSelector = labelSelector.Add(*noProxyName, *noEdgeMeshProxyName, *hasGateway)
+		configSyncPeriod := metav1.Duration{Duration: 15 * time.Minute}
+
+		labelSelector := ServiceSelector()
 
 		client := ifm.GetKubeClient()
 
@@ -63,33 +90,218 @@ func Init(ifm *informers.Manager, cfg *config.EdgeAutoGwConfig) {
 			k8sinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
 				options.LabelSelector = labelSelector.String()
 			}))
+		svcInformer := informerFactory.Core().V1().Services()
 
 		APIConn = &AutoGatewayController{
-			atInformer:      informerFactory.Core().V1().Services().Informer(),
-			atEventHandlers: make(map[string]cache.ResourceEventHandlerFuncs),
+			atInformer: svcInformer.Informer(),
+			atLister:   svcInformer.Lister(),
+			queue:      newFairQueue(workqueue.DefaultControllerRateLimiter()),
 		}
 		ifm.RegisterInformer(APIConn.atInformer)
 		ifm.RegisterSyncedFunc(APIConn.onCacheSynced)
+		sharding.RegisterRebalanceFunc(APIConn.Resync)
 	})
 }
 
 func (c *AutoGatewayController) onCacheSynced() {
-
-	for name, funcs := range c.atEventHandlers {
-		klog.V(4).Infof("enable edge-auto-gw event handler funcs: %s", name)
-		c.atInformer.AddEventHandler(funcs)
-	}
+	klog.V(4).Infof("enable edge-auto-gw service event handler")
+	c.atInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if c.onlyIgnoredChanged(oldObj, newObj) {
+				return
+			}
+			c.enqueue(newObj)
+		},
+		DeleteFunc: c.enqueue,
+	})
 
 	// set informers event handler
 	// c.gwInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 	// 	AddFunc: c.gwAdd, UpdateFunc: c.gwUpdate, DeleteFunc: c.gwDelete})
 }
 
-func (c *AutoGatewayController) SetAutoGatewayEventHandlers(name string, handlerFuncs cache.ResourceEventHandlerFuncs) {
+// enqueue adds the namespace/name key of a service to the work queue,
+// tombstones of deleted services are resolved to their key as well
+func (c *AutoGatewayController) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %+v: %v", obj, err))
+		return
+	}
+	c.Enqueue(key)
+}
+
+// Enqueue adds a namespace/name service key to the work queue,
+// keys of namespaces outside the shard of this instance are left to their owner
+func (c *AutoGatewayController) Enqueue(key string) {
+	if !ownsKey(key) {
+		klog.V(4).Infof("skip service %s of another shard", key)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Resync queues every labeled service, so the namespaces this instance
+// took over from another shard are reconciled right away
+func (c *AutoGatewayController) Resync() {
+	svcs, err := c.atLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("list edge-auto-gw services for resync failed: %v", err)
+		return
+	}
+	for _, svc := range svcs {
+		key, err := cache.MetaNamespaceKeyFunc(svc)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		c.Enqueue(key)
+	}
+}
+
+// ownsKey reports whether the service key belongs to the shard of this instance
+func ownsKey(key string) bool {
+	ns, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return true
+	}
+	return sharding.Owns(ns)
+}
+
+// IgnoreAnnotationChanges stops service updates which only change the annotations keys from
+// queueing the service, for annotations edge-auto-gw writes back onto the service
+func (c *AutoGatewayController) IgnoreAnnotationChanges(keys ...string) {
+	c.Lock()
+	c.ignoredAnnotations = append(c.ignoredAnnotations, keys...)
+	c.Unlock()
+}
+
+// onlyIgnoredChanged reports whether a service update only changed ignored annotations
+func (c *AutoGatewayController) onlyIgnoredChanged(oldObj, newObj interface{}) bool {
+	oldSvc, ok := oldObj.(*v1.Service)
+	if !ok {
+		return false
+	}
+	newSvc, ok := newObj.(*v1.Service)
+	if !ok {
+		return false
+	}
+
+	c.RLock()
+	keys := c.ignoredAnnotations
+	c.RUnlock()
+	if len(keys) == 0 {
+		return false
+	}
+
+	oldSvc, newSvc = oldSvc.DeepCopy(), newSvc.DeepCopy()
+	for _, svc := range []*v1.Service{oldSvc, newSvc} {
+		svc.ResourceVersion = ""
+		svc.ManagedFields = nil
+		for _, key := range keys {
+			delete(svc.Annotations, key)
+		}
+	}
+	return apiequality.Semantic.DeepEqual(oldSvc, newSvc)
+}
+
+// SetReconcileFunc sets the function which is called by the workers for every queued service key
+func (c *AutoGatewayController) SetReconcileFunc(fn ReconcileFunc) {
 	c.Lock()
-	if _, exist := c.atEventHandlers[name]; exist {
-		klog.Warningf("edge-auto-gw event handler %s already exists, it will be overwritten!", name)
+	if c.reconcile != nil {
+		klog.Warningf("edge-auto-gw reconcile func already exists, it will be overwritten!")
 	}
-	c.atEventHandlers[name] = handlerFuncs
+	c.reconcile = fn
 	c.Unlock()
 }
+
+// GetService returns the labeled service namespace/name from the informer cache
+func (c *AutoGatewayController) GetService(namespace, name string) (*v1.Service, error) {
+	return c.atLister.Services(namespace).Get(name)
+}
+
+// Run starts workers which process the queued service keys until stopCh is closed,
+// it returns once the reconciles in flight at that time are finished
+func (c *AutoGatewayController) Run(workers int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	klog.Infof("starting %d edge-auto-gw workers", workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(c.runWorker, time.Second, stopCh)
+		}()
+	}
+
+	<-stopCh
+	klog.Infof("shutting down edge-auto-gw workers, waiting for in-flight reconciles")
+	c.queue.ShutDown()
+	wg.Wait()
+	klog.Infof("edge-auto-gw workers stopped")
+}
+
+func (c *AutoGatewayController) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *AutoGatewayController) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	// the keys still queued at shutdown are picked up again by the next start
+	if c.queue.ShuttingDown() {
+		return false
+	}
+
+	// the namespace moved to another shard while the key was queued
+	if !ownsKey(key.(string)) {
+		c.queue.Forget(key)
+		return true
+	}
+
+	c.RLock()
+	reconcile := c.reconcile
+	c.RUnlock()
+	if reconcile == nil {
+		klog.Warningf("no edge-auto-gw reconcile func, drop service %v", key)
+		c.queue.Forget(key)
+		return true
+	}
+
+	start := time.Now()
+	err := reconcile(key.(string))
+	result := metrics.ResultSuccess
+	if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.Reconciles.WithLabelValues(result).Inc()
+	metrics.ReconcileDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	c.handleErr(err, key)
+	return true
+}
+
+// handleErr retries a failed key with exponential backoff until maxRetries is reached
+func (c *AutoGatewayController) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.queue.Forget(key)
+		return
+	}
+
+	if c.queue.NumRequeues(key) < maxRetries {
+		klog.Warningf("error syncing service %v, retrying: %v", key, err)
+		c.queue.AddRateLimited(key)
+		return
+	}
+
+	klog.Errorf("dropping service %v out of the queue: %v", key, err)
+	c.queue.Forget(key)
+	utilruntime.HandleError(err)
+}
@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
+)
+
+// fairQueue is a rate limited work queue which hands out the queued service keys round-robin
+// over their namespaces, so a namespace which queues hundreds of services at once does not
+// delay the services of other namespaces. Like the client-go work queue it de-duplicates
+// queued keys and never hands the same key to two workers at once.
+type fairQueue struct {
+	cond *sync.Cond
+
+	// active holds the namespaces with queued keys in the order they are served
+	active []string
+	// queues holds the queued keys of every active namespace in FIFO order
+	queues map[string][]interface{}
+	// dirty holds the keys which need processing, queued or waiting for a worker to finish
+	dirty map[interface{}]struct{}
+	// processing holds the keys handed to a worker
+	processing map[interface{}]struct{}
+	// length is the number of queued keys over all namespaces
+	length int
+
+	shuttingDown bool
+	rateLimiter  workqueue.RateLimiter
+}
+
+var _ workqueue.RateLimitingInterface = &fairQueue{}
+
+func newFairQueue(rateLimiter workqueue.RateLimiter) *fairQueue {
+	return &fairQueue{
+		cond:        sync.NewCond(&sync.Mutex{}),
+		queues:      make(map[string][]interface{}),
+		dirty:       make(map[interface{}]struct{}),
+		processing:  make(map[interface{}]struct{}),
+		rateLimiter: rateLimiter,
+	}
+}
+
+// namespaceOf returns the namespace of a namespace/name service key
+func namespaceOf(item interface{}) string {
+	key, ok := item.(string)
+	if !ok {
+		return ""
+	}
+	ns, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return ""
+	}
+	return ns
+}
+
+// push appends item to the queue of its namespace, the caller holds the lock
+func (q *fairQueue) push(item interface{}) {
+	ns := namespaceOf(item)
+	if _, ok := q.queues[ns]; !ok {
+		q.active = append(q.active, ns)
+	}
+	q.queues[ns] = append(q.queues[ns], item)
+	q.length++
+	metrics.QueueDepth.Set(float64(q.length))
+	metrics.NamespaceQueueDepth.WithLabelValues(ns).Set(float64(len(q.queues[ns])))
+	q.cond.Signal()
+}
+
+// pop takes the next key of the next namespace in turn, the caller holds the lock
+func (q *fairQueue) pop() interface{} {
+	ns := q.active[0]
+	q.active = q.active[1:]
+
+	queue := q.queues[ns]
+	item := queue[0]
+	queue[0] = nil
+	if len(queue) > 1 {
+		q.queues[ns] = queue[1:]
+		q.active = append(q.active, ns)
+		metrics.NamespaceQueueDepth.WithLabelValues(ns).Set(float64(len(queue) - 1))
+	} else {
+		delete(q.queues, ns)
+		metrics.NamespaceQueueDepth.DeleteLabelValues(ns)
+	}
+	q.length--
+	metrics.QueueDepth.Set(float64(q.length))
+	return item
+}
+
+// Add marks item as needing processing
+func (q *fairQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		// queued again once the worker is done with it
+		return
+	}
+	q.push(item)
+}
+
+// Len returns the number of queued keys
+func (q *fairQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.length
+}
+
+// Get blocks until it can return a key to be processed. If shutdown = true,
+// the caller should end their goroutine. You must call Done with item when you
+// have finished processing it.
+func (q *fairQueue) Get() (item interface{}, shutdown bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for q.length == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.length == 0 {
+		// we must be shutting down
+		return nil, true
+	}
+
+	item = q.pop()
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done marks item as done processing, and if it has been marked as dirty again
+// while it was being processed, it will be re-added to the queue for re-processing.
+func (q *fairQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+	}
+}
+
+// ShutDown causes Get to return shutdown = true once the queued keys are drained
+func (q *fairQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+func (q *fairQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter adds item after the given delay
+func (q *fairQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	time.AfterFunc(duration, func() { q.Add(item) })
+}
+
+// AddRateLimited adds item after the rate limiter says it's ok
+func (q *fairQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget indicates that an item is finished being retried
+func (q *fairQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns back how many times the item was requeued
+func (q *fairQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestQueue() *fairQueue {
+	return newFairQueue(workqueue.DefaultControllerRateLimiter())
+}
+
+// drain gets and finishes every queued key, returning them in the order they were handed out
+func drain(q *fairQueue) []interface{} {
+	var got []interface{}
+	for q.Len() > 0 {
+		item, _ := q.Get()
+		got = append(got, item)
+		q.Done(item)
+	}
+	return got
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	tests := []struct {
+		name string
+		add  []string
+		want []interface{}
+	}{
+		{
+			name: "single namespace keeps FIFO order",
+			add:  []string{"a/1", "a/2", "a/3"},
+			want: []interface{}{"a/1", "a/2", "a/3"},
+		},
+		{
+			name: "namespaces take turns",
+			add:  []string{"a/1", "a/2", "a/3", "b/1", "c/1", "b/2"},
+			want: []interface{}{"a/1", "b/1", "c/1", "a/2", "b/2", "a/3"},
+		},
+		{
+			name: "noisy namespace does not delay others",
+			add:  []string{"noisy/1", "noisy/2", "noisy/3", "noisy/4", "quiet/1"},
+			want: []interface{}{"noisy/1", "quiet/1", "noisy/2", "noisy/3", "noisy/4"},
+		},
+		{
+			name: "duplicate keys are queued once",
+			add:  []string{"a/1", "b/1", "a/1", "b/1", "a/2"},
+			want: []interface{}{"a/1", "b/1", "a/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue()
+			for _, key := range tt.add {
+				q.Add(key)
+			}
+			if q.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", q.Len(), len(tt.want))
+			}
+			if got := drain(q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys handed out in order %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFairQueueProcessing(t *testing.T) {
+	tests := []struct {
+		name string
+		// addWhileProcessing is how often the key is added while a worker holds it
+		addWhileProcessing int
+		wantRequeued       bool
+	}{
+		{name: "done without changes", addWhileProcessing: 0, wantRequeued: false},
+		{name: "added while processing", addWhileProcessing: 1, wantRequeued: true},
+		{name: "added several times while processing", addWhileProcessing: 3, wantRequeued: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue()
+			q.Add("a/1")
+			item, _ := q.Get()
+
+			for i := 0; i < tt.addWhileProcessing; i++ {
+				q.Add("a/1")
+			}
+			if q.Len() != 0 {
+				t.Fatalf("key held by a worker was queued again, Len() = %d", q.Len())
+			}
+
+			q.Done(item)
+			want := 0
+			if tt.wantRequeued {
+				want = 1
+			}
+			if q.Len() != want {
+				t.Fatalf("Len() after Done = %d, want %d", q.Len(), want)
+			}
+			if tt.wantRequeued {
+				if again, _ := q.Get(); again != "a/1" {
+					t.Errorf("requeued key is %v, want a/1", again)
+				}
+			}
+		})
+	}
+}
+
+func TestFairQueueShutDown(t *testing.T) {
+	q := newTestQueue()
+	q.Add("a/1")
+	q.Add("b/1")
+	q.ShutDown()
+	q.Add("c/1")
+	q.AddAfter("c/2", 0)
+
+	if !q.ShuttingDown() {
+		t.Errorf("ShuttingDown() = false after ShutDown")
+	}
+	var got []interface{}
+	for {
+		item, shutdown := q.Get()
+		if shutdown {
+			break
+		}
+		got = append(got, item)
+		q.Done(item)
+	}
+	if want := []interface{}{"a/1", "b/1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("drained %v after ShutDown, want %v", got, want)
+	}
+}
+
+func TestFairQueueShutDownWakesWorkers(t *testing.T) {
+	q := newTestQueue()
+	done := make(chan bool)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, shutdown := q.Get()
+			done <- shutdown
+		}()
+	}
+
+	q.ShutDown()
+	for i := 0; i < 3; i++ {
+		select {
+		case shutdown := <-done:
+			if !shutdown {
+				t.Errorf("Get on an empty queue returned a key after ShutDown")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("worker blocked in Get was not woken by ShutDown")
+		}
+	}
+}
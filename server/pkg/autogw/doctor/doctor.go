@@ -0,0 +1,337 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/yz271544/edge-auto-gw/server/common/constants"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	// StatusWarn is a problem edge-auto-gw runs with, but some exposures will not work
+	StatusWarn Status = "WARN"
+	// StatusFail is a problem edge-auto-gw can not run with
+	StatusFail Status = "FAIL"
+)
+
+// Result is the outcome of one check, Hint tells how to fix a failed check
+type Result struct {
+	Name    string
+	Status  Status
+	Message string
+	Hint    string
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("[%s] %s: %s", r.Status, r.Name, r.Message)
+	if r.Hint != "" {
+		s += "\n       " + strings.ReplaceAll(r.Hint, "\n", "\n       ")
+	}
+	return s
+}
+
+// Options tell which permissions edge-auto-gw needs
+type Options struct {
+	// ReadOnly is set for dry-run, nothing is written to the cluster
+	ReadOnly bool
+	// LeaderElectionNamespace is the namespace of the leader lease, empty without leader election
+	LeaderElectionNamespace string
+	// ShardingNamespace is the namespace of the shard leases, empty without sharding
+	ShardingNamespace string
+}
+
+// istioResources are the istio resources edge-auto-gw generates
+var istioResources = []string{"gateways", "virtualservices", "destinationrules"}
+
+// Run runs all checks against the cluster of client
+func Run(ctx context.Context, client kubernetes.Interface, opts Options) []Result {
+	results := []Result{checkIstioAPI(client), checkRBAC(ctx, client, opts), checkLabelKeys()}
+	results = append(results, checkEdgeMeshGateway(ctx, client), checkExposures(ctx, client))
+	return results
+}
+
+// Failed reports whether one of results failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIstioAPI checks the istio networking CRDs are installed and serve the version edge-auto-gw writes
+func checkIstioAPI(client kubernetes.Interface) Result {
+	r := Result{Name: "istio-crds"}
+	group := istioapi.SchemeGroupVersion.Group
+	groups, err := client.Discovery().ServerGroups()
+	if err != nil {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("discover API groups failed: %v", err)
+		return r
+	}
+
+	var versions []string
+	preferred := ""
+	for _, g := range groups.Groups {
+		if g.Name != group {
+			continue
+		}
+		for _, v := range g.Versions {
+			versions = append(versions, v.Version)
+		}
+		preferred = g.PreferredVersion.Version
+	}
+	if len(versions) == 0 {
+		r.Status, r.Message = StatusFail, fmt.Sprintf("API group %s is not served", group)
+		r.Hint = "install the istio CRDs, e.g. istioctl install or the base chart of istio"
+		return r
+	}
+
+	gv := istioapi.SchemeGroupVersion.String()
+	served := sets.NewString()
+	if list, err := client.Discovery().ServerResourcesForGroupVersion(gv); err == nil {
+		for _, res := range list.APIResources {
+			served.Insert(res.Name)
+		}
+	}
+	var missing []string
+	for _, res := range istioResources {
+		if !served.Has(res) {
+			missing = append(missing, res)
+		}
+	}
+	if len(missing) > 0 {
+		r.Status = StatusFail
+		r.Message = fmt.Sprintf("%s serves %s but not %s of %s", group, strings.Join(versions, ", "),
+			strings.Join(missing, ", "), gv)
+		r.Hint = fmt.Sprintf("install istio CRDs which serve %s, edge-auto-gw writes that version", gv)
+		return r
+	}
+	r.Status = StatusPass
+	r.Message = fmt.Sprintf("%s serves %s (preferred %s)", group, strings.Join(versions, ", "), preferred)
+	return r
+}
+
+// permission is an access edge-auto-gw needs
+type permission struct {
+	group, resource, subresource, namespace string
+	verbs                                   []string
+}
+
+func (p permission) String(verb string) string {
+	resource := p.resource
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	if p.namespace != "" {
+		return fmt.Sprintf("%s %s in %s", verb, resource, p.namespace)
+	}
+	return verb + " " + resource
+}
+
+// requiredPermissions returns what edge-auto-gw needs with opts
+func requiredPermissions(opts Options) []permission {
+	istioVerbs := []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+	serviceVerbs := []string{"get", "list", "watch", "patch"}
+	if opts.ReadOnly {
+		istioVerbs = []string{"get", "list", "watch"}
+		serviceVerbs = []string{"get", "list", "watch"}
+	}
+
+	group := istioapi.SchemeGroupVersion.Group
+	perms := []permission{{resource: "services", verbs: serviceVerbs}}
+	if !opts.ReadOnly {
+		// adding the cleanup finalizer and owner references which block the deletion of the
+		// service needs it with the OwnerReferencesPermissionEnforcement admission plugin
+		perms = append(perms, permission{resource: "services", subresource: "finalizers", verbs: []string{"update"}})
+	}
+	for _, res := range istioResources {
+		perms = append(perms, permission{group: group, resource: res, verbs: istioVerbs})
+	}
+	if !opts.ReadOnly {
+		perms = append(perms, permission{resource: "events", verbs: []string{"create", "patch"}})
+		if opts.LeaderElectionNamespace != "" {
+			perms = append(perms, permission{group: "coordination.k8s.io", resource: "leases",
+				namespace: opts.LeaderElectionNamespace, verbs: []string{"get", "create", "update"}})
+		}
+		if opts.ShardingNamespace != "" {
+			perms = append(perms, permission{group: "coordination.k8s.io", resource: "leases",
+				namespace: opts.ShardingNamespace, verbs: []string{"get", "list", "create", "update", "delete"}})
+		}
+	}
+	return perms
+}
+
+// checkRBAC asks the API server whether the current user may do what edge-auto-gw does
+func checkRBAC(ctx context.Context, client kubernetes.Interface, opts Options) Result {
+	r := Result{Name: "rbac"}
+	var denied []string
+	checked := 0
+	for _, p := range requiredPermissions(opts) {
+		for _, verb := range p.verbs {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Group: p.group, Resource: p.resource, Subresource: p.subresource, Namespace: p.namespace, Verb: verb,
+					},
+				},
+			}
+			resp, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				r.Status, r.Message = StatusFail, fmt.Sprintf("review access failed: %v", err)
+				return r
+			}
+			checked++
+			if !resp.Status.Allowed {
+				denied = append(denied, p.String(verb))
+			}
+		}
+	}
+	if len(denied) > 0 {
+		r.Status = StatusFail
+		r.Message = "missing permissions: " + strings.Join(denied, ", ")
+		r.Hint = "apply build/kubernetes/03-clusterrole.yaml and bind it to the service account of edge-auto-gw"
+		return r
+	}
+	r.Status, r.Message = StatusPass, fmt.Sprintf("all %d permissions granted", checked)
+	return r
+}
+
+// checkLabelKeys checks the label keys edge-auto-gw selects services and gateway pods by are valid
+func checkLabelKeys() Result {
+	r := Result{Name: "label-keys"}
+	var errs []string
+	for _, key := range []string{controller.LabelEdgemeshGatewayProtocols, controller.LabelEdgemeshGatewayPort,
+		constants.SelectorForEdgeMeshGatewayKey} {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Sprintf("label key %q: %s", key, msg))
+		}
+	}
+	for _, msg := range validation.IsValidLabelValue(constants.SelectorForEdgeMeshGatewayValue) {
+		errs = append(errs, fmt.Sprintf("label value %q: %s", constants.SelectorForEdgeMeshGatewayValue, msg))
+	}
+	if _, err := labels.Parse(controller.ServiceSelector().String()); err != nil {
+		errs = append(errs, fmt.Sprintf("service selector: %v", err))
+	}
+	if len(errs) > 0 {
+		r.Status, r.Message = StatusFail, strings.Join(errs, "; ")
+		return r
+	}
+	r.Status, r.Message = StatusPass, fmt.Sprintf("services are selected by %s", controller.ServiceSelector())
+	return r
+}
+
+// checkEdgeMeshGateway checks there are edgemesh-gateway pods the generated gateways select
+func checkEdgeMeshGateway(ctx context.Context, client kubernetes.Interface) Result {
+	r := Result{Name: "edgemesh-gateway"}
+	selector := labels.SelectorFromSet(labels.Set{
+		constants.SelectorForEdgeMeshGatewayKey: constants.SelectorForEdgeMeshGatewayValue,
+	}).String()
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		r.Status, r.Message = StatusWarn, fmt.Sprintf("list pods %s failed: %v", selector, err)
+		return r
+	}
+	if len(pods.Items) == 0 {
+		r.Status, r.Message = StatusWarn, fmt.Sprintf("no pods labeled %s, the exposures are not reachable", selector)
+		r.Hint = "deploy edgemesh-gateway, its pods must carry the label " + selector
+		return r
+	}
+
+	ready := 0
+	for i := range pods.Items {
+		for _, c := range pods.Items[i].Status.Conditions {
+			if c.Type == v1.PodReady && c.Status == v1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	if ready == 0 {
+		r.Status, r.Message = StatusWarn, fmt.Sprintf("none of %d pods labeled %s is ready", len(pods.Items), selector)
+		r.Hint = "check the edgemesh-gateway pods with kubectl describe pod -l " + selector + " -A"
+		return r
+	}
+	r.Status, r.Message = StatusPass, fmt.Sprintf("%d of %d pods labeled %s are ready", ready, len(pods.Items), selector)
+	return r
+}
+
+// checkExposures checks the gateway labels of every selected service parse and no two
+// services expose the same gateway port
+func checkExposures(ctx context.Context, client kubernetes.Interface) Result {
+	r := Result{Name: "exposures"}
+	svcs, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: controller.ServiceSelector().String(),
+	})
+	if err != nil {
+		r.Status, r.Message = StatusWarn, fmt.Sprintf("list services failed: %v", err)
+		return r
+	}
+
+	var problems []string
+	users := make(map[uint32][]string)
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		name := svc.Namespace + "/" + svc.Name
+		exposure, err := manager.RenderExposure(svc)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		for _, port := range exposure.Labels.GatewayPort {
+			users[port] = append(users[port], name)
+		}
+	}
+	ports := make([]int, 0, len(users))
+	for port := range users {
+		ports = append(ports, int(port))
+	}
+	sort.Ints(ports)
+	for _, port := range ports {
+		if names := users[uint32(port)]; len(names) > 1 {
+			problems = append(problems, fmt.Sprintf("gateway port %d is exposed by %s", port, strings.Join(names, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		r.Status = StatusWarn
+		r.Message = fmt.Sprintf("%d problems in %d exposures:\n  %s", len(problems), len(svcs.Items), strings.Join(problems, "\n  "))
+		example := exampleLabels()
+		r.Hint = fmt.Sprintf("fix the labels %s (e.g. %s) and %s (servicePort%sgatewayPort, e.g. %s)",
+			controller.LabelEdgemeshGatewayProtocols, example[controller.LabelEdgemeshGatewayProtocols],
+			controller.LabelEdgemeshGatewayPort, manager.GatewayPortSeparate, example[controller.LabelEdgemeshGatewayPort])
+		return r
+	}
+	r.Status, r.Message = StatusPass, fmt.Sprintf("%d exposures are valid", len(svcs.Items))
+	return r
+}
+
+// exampleLabels returns gateway labels exposing ssh and http, built from the separators the labels are parsed with
+func exampleLabels() map[string]string {
+	return map[string]string{
+		controller.LabelEdgemeshGatewayProtocols: strings.Join([]string{"TCP", "HTTP"}, manager.GroupSparate),
+		controller.LabelEdgemeshGatewayPort: strings.Join([]string{
+			"22" + manager.GatewayPortSeparate + "30022",
+			"80" + manager.GatewayPortSeparate + "30080",
+		}, manager.GroupSparate),
+	}
+}
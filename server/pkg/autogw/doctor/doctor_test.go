@@ -0,0 +1,83 @@
+package doctor
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
+)
+
+func TestExampleLabelsParse(t *testing.T) {
+	svc := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "example", Namespace: "default", Labels: exampleLabels()},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 22}, {Port: 80}}},
+	}
+	exposure, err := manager.RenderExposure(svc)
+	if err != nil {
+		t.Fatalf("example labels %v do not parse: %v", exampleLabels(), err)
+	}
+	if got := exposure.Labels.GatewayPort; len(got) != 2 || got[0] != 30022 || got[1] != 30080 {
+		t.Errorf("gateway ports of the example are %v, want [30022 30080]", got)
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	has := func(perms []permission, name string) bool {
+		for _, p := range perms {
+			for _, verb := range p.verbs {
+				if p.String(verb) == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]bool
+	}{
+		{
+			name: "default",
+			opts: Options{},
+			want: map[string]bool{
+				"update services/finalizers":                    true,
+				"patch services":                                true,
+				"delete gateways.networking.istio.io":           true,
+				"create events":                                 true,
+				"update leases.coordination.k8s.io in kubeedge": false,
+			},
+		},
+		{
+			name: "read only",
+			opts: Options{ReadOnly: true, LeaderElectionNamespace: "kubeedge"},
+			want: map[string]bool{
+				"update services/finalizers":                    false,
+				"patch services":                                false,
+				"list gateways.networking.istio.io":             true,
+				"update leases.coordination.k8s.io in kubeedge": false,
+			},
+		},
+		{
+			name: "leader election",
+			opts: Options{LeaderElectionNamespace: "kubeedge"},
+			want: map[string]bool{
+				"update leases.coordination.k8s.io in kubeedge": true,
+				"delete leases.coordination.k8s.io in kubeedge": false,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms := requiredPermissions(tt.opts)
+			for name, want := range tt.want {
+				if got := has(perms, name); got != want {
+					t.Errorf("permission %q required = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
+)
+
+// applyResult indicates what an apply call did to the live object
+type applyResult string
+
+const (
+	resultCreated   applyResult = "created"
+	resultUpdated   applyResult = "updated"
+	resultUnchanged applyResult = "unchanged"
+	// resultReclaimed means fields another field manager changed were forced back
+	resultReclaimed applyResult = "reclaimed"
+)
+
+// OwnershipConflictError is returned when an object which was not generated by edge-auto-gw
+// is in the way of a generated object
+type OwnershipConflictError struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (e *OwnershipConflictError) Error() string {
+	return fmt.Sprintf("%s %s.%s exists but is not managed by %s, delete it or enable adopt",
+		e.Kind, e.Namespace, e.Name, ManagedByValue)
+}
+
+// IsOwnershipConflict reports whether err is caused by an unmanaged object
+func IsOwnershipConflict(err error) bool {
+	var conflict *OwnershipConflictError
+	return errors.As(err, &conflict)
+}
+
+// checkOwnership returns an OwnershipConflictError for an unmanaged live object,
+// unless unmanaged objects are adopted
+func (mgr *AutoGwManager) checkOwnership(kind string, live metav1.Object) error {
+	if IsManaged(live) {
+		return nil
+	}
+	if !mgr.cfg.Adopt {
+		return &OwnershipConflictError{Kind: kind, Namespace: live.GetNamespace(), Name: live.GetName()}
+	}
+	klog.Infof("adopt unmanaged %s %s.%s", kind, live.GetNamespace(), live.GetName())
+	return nil
+}
+
+// checkExposureOwnership checks the cached objects of exposure for unmanaged ones before any
+// of them is written, the cache may miss a new object which serverSideApply still checks
+func (mgr *AutoGwManager) checkExposureOwnership(exposure *Exposure) error {
+	ns, nm := exposure.Gateway.Namespace, exposure.Gateway.Name
+	if dr, err := mgr.drLister.DestinationRules(ns).Get(nm); err == nil && !IsManaged(dr) && !mgr.cfg.Adopt {
+		return &OwnershipConflictError{Kind: "DestinationRule", Namespace: ns, Name: nm}
+	}
+	if vs, err := mgr.vsLister.VirtualServices(ns).Get(nm); err == nil && !IsManaged(vs) && !mgr.cfg.Adopt {
+		return &OwnershipConflictError{Kind: "VirtualService", Namespace: ns, Name: nm}
+	}
+	if gw, err := mgr.gwLister.Gateways(ns).Get(nm); err == nil && !IsManaged(gw) && !mgr.cfg.Adopt {
+		return &OwnershipConflictError{Kind: "Gateway", Namespace: ns, Name: nm}
+	}
+	return nil
+}
+
+// FieldManager is the field manager edge-auto-gw applies the generated objects with
+const FieldManager = "edge-auto-gw"
+
+// applyPatchFunc sends a server-side apply patch and returns the resulting object
+type applyPatchFunc func(data []byte, opts metav1.PatchOptions) (metav1.Object, error)
+
+// serverSideApply applies the apply configuration ac of a generated object with the edge-auto-gw
+// field manager, so fields set by other tools are left alone. live is the current object, nil
+// if it does not exist yet. The caller reads it from the API server when the cache misses it,
+// so an object created by the previous reconcile is not reported as created and rolled back.
+// A field edge-auto-gw owns which another field manager changed is forced back, unless
+// FailOnConflicts fails the apply instead.
+func (mgr *AutoGwManager) serverSideApply(kind string, live metav1.Object, ac interface{}, patch applyPatchFunc) (applyResult, error) {
+	force := false
+	if live != nil {
+		if err := mgr.checkOwnership(kind, live); err != nil {
+			return "", err
+		}
+		// an adopted object was written by another field manager
+		force = !IsManaged(live)
+	}
+
+	data, err := json.Marshal(ac)
+	if err != nil {
+		return "", fmt.Errorf("marshal %s apply configuration failed: %v", kind, err)
+	}
+
+	opts := metav1.PatchOptions{FieldManager: FieldManager, Force: &force}
+	applied, err := patch(data, opts)
+	reclaimed := false
+	if apierrors.IsConflict(err) {
+		if mgr.cfg.FailOnConflicts {
+			countIstioError("patch", kind, err)
+			return "", fmt.Errorf("%s fields owned by %s were changed by another field manager: %w", kind, FieldManager, err)
+		}
+		klog.Warningf("%s fields owned by %s were changed by another field manager, taking them back: %v", kind, FieldManager, err)
+		force, reclaimed = true, true
+		applied, err = patch(data, opts)
+	}
+	if err != nil {
+		countIstioError("patch", kind, err)
+		return "", err
+	}
+
+	switch {
+	case live == nil:
+		return resultCreated, nil
+	case reclaimed:
+		return resultReclaimed, nil
+	case live.GetResourceVersion() == applied.GetResourceVersion():
+		return resultUnchanged, nil
+	default:
+		return resultUpdated, nil
+	}
+}
+
+// specHash returns the hash of the rendered object, given as its apply configuration
+func specHash(ac interface{}) (string, error) {
+	data, err := json.Marshal(ac)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// stampSpecHash records the hash of the rendered object ac on the annotations of meta
+func stampSpecHash(meta *metav1.ObjectMeta, ac interface{}) (string, error) {
+	hash, err := specHash(ac)
+	if err != nil {
+		return "", fmt.Errorf("hash rendered %s.%s failed: %v", meta.Namespace, meta.Name, err)
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[AnnotationSpecHash] = hash
+	return hash, nil
+}
+
+// upToDate reports whether the cached object was written from the current rendering
+func upToDate(cached metav1.Object, hash string) bool {
+	return IsManaged(cached) && cached.GetAnnotations()[AnnotationSpecHash] == hash
+}
+
+// ownedFieldsMatch reports whether the live object, given as its apply configuration, carries
+// every field of the apply configuration desired. Fields set by other field managers are not
+// owned by edge-auto-gw and ignored, applying again would not change them.
+func ownedFieldsMatch(live, desired interface{}) bool {
+	var liveFields, desiredFields interface{}
+	if err := roundTrip(live, &liveFields); err != nil {
+		return false
+	}
+	if err := roundTrip(desired, &desiredFields); err != nil {
+		return false
+	}
+	return containsFields(liveFields, desiredFields)
+}
+
+// roundTrip decodes the JSON of in into out
+func roundTrip(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// containsFields reports whether every field of the decoded JSON value want is set to the same
+// value in has. Lists are replaced as a whole by an apply, so they must have the same length.
+func containsFields(has, want interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := has.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if !containsFields(h[k], v) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		h, ok := has.([]interface{})
+		if !ok || len(h) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !containsFields(h[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(has, want)
+	}
+}
+
+// applyDestinationRule server-side applies the generated destination rule, unless the
+// cached one already matches the rendering
+func (mgr *AutoGwManager) applyDestinationRule(desired *istioapi.DestinationRule) (applyResult, error) {
+	desired = desired.DeepCopy()
+	hash, err := stampSpecHash(&desired.ObjectMeta, DestinationRuleApplyConfigurationFor(desired))
+	if err != nil {
+		return "", err
+	}
+
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().DestinationRules(desired.Namespace)
+	var live metav1.Object
+	cached, err := mgr.drLister.DestinationRules(desired.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		cached, err = client.Get(context.Background(), desired.Name, metav1.GetOptions{})
+		err = countIstioError("get", "DestinationRule", err)
+	}
+	if err == nil {
+		// a changed field with the current hash was edited by others
+		if upToDate(cached, hash) && ownedFieldsMatch(DestinationRuleApplyConfigurationFor(cached), DestinationRuleApplyConfigurationFor(desired)) {
+			return resultUnchanged, nil
+		}
+		live = cached
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if mgr.cfg.DryRun {
+		var liveSpec interface{}
+		if live != nil {
+			liveSpec = &cached.Spec
+		}
+		return mgr.dryRunApply("DestinationRule", desired, &desired.Spec, live, liveSpec)
+	}
+
+	return mgr.serverSideApply("DestinationRule", live, DestinationRuleApplyConfigurationFor(desired),
+		func(data []byte, opts metav1.PatchOptions) (metav1.Object, error) {
+			return client.Patch(context.Background(), desired.Name, types.ApplyPatchType, data, opts)
+		})
+}
+
+// applyVirtualService server-side applies the generated virtual service, unless the
+// cached one already matches the rendering
+func (mgr *AutoGwManager) applyVirtualService(desired *istioapi.VirtualService) (applyResult, error) {
+	desired = desired.DeepCopy()
+	hash, err := stampSpecHash(&desired.ObjectMeta, VirtualServiceApplyConfigurationFor(desired))
+	if err != nil {
+		return "", err
+	}
+
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().VirtualServices(desired.Namespace)
+	var live metav1.Object
+	cached, err := mgr.vsLister.VirtualServices(desired.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		cached, err = client.Get(context.Background(), desired.Name, metav1.GetOptions{})
+		err = countIstioError("get", "VirtualService", err)
+	}
+	if err == nil {
+		if upToDate(cached, hash) && ownedFieldsMatch(VirtualServiceApplyConfigurationFor(cached), VirtualServiceApplyConfigurationFor(desired)) {
+			return resultUnchanged, nil
+		}
+		live = cached
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if mgr.cfg.DryRun {
+		var liveSpec interface{}
+		if live != nil {
+			liveSpec = &cached.Spec
+		}
+		return mgr.dryRunApply("VirtualService", desired, &desired.Spec, live, liveSpec)
+	}
+
+	return mgr.serverSideApply("VirtualService", live, VirtualServiceApplyConfigurationFor(desired),
+		func(data []byte, opts metav1.PatchOptions) (metav1.Object, error) {
+			return client.Patch(context.Background(), desired.Name, types.ApplyPatchType, data, opts)
+		})
+}
+
+// applyGateway server-side applies the generated gateway, unless the
+// cached one already matches the rendering
+func (mgr *AutoGwManager) applyGateway(desired *istioapi.Gateway) (applyResult, error) {
+	desired = desired.DeepCopy()
+	hash, err := stampSpecHash(&desired.ObjectMeta, GatewayApplyConfigurationFor(desired))
+	if err != nil {
+		return "", err
+	}
+
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().Gateways(desired.Namespace)
+	var live metav1.Object
+	cached, err := mgr.gwLister.Gateways(desired.Namespace).Get(desired.Name)
+	if apierrors.IsNotFound(err) {
+		cached, err = client.Get(context.Background(), desired.Name, metav1.GetOptions{})
+		err = countIstioError("get", "Gateway", err)
+	}
+	if err == nil {
+		if upToDate(cached, hash) && ownedFieldsMatch(GatewayApplyConfigurationFor(cached), GatewayApplyConfigurationFor(desired)) {
+			return resultUnchanged, nil
+		}
+		live = cached
+	} else if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if mgr.cfg.DryRun {
+		var liveSpec interface{}
+		if live != nil {
+			liveSpec = &cached.Spec
+		}
+		return mgr.dryRunApply("Gateway", desired, &desired.Spec, live, liveSpec)
+	}
+
+	return mgr.serverSideApply("Gateway", live, GatewayApplyConfigurationFor(desired),
+		func(data []byte, opts metav1.PatchOptions) (metav1.Object, error) {
+			return client.Patch(context.Background(), desired.Name, types.ApplyPatchType, data, opts)
+		})
+}
+
+// deleteDestinationRule deletes the managed destination rule, a missing or unmanaged one is left alone
+func (mgr *AutoGwManager) deleteDestinationRule(namespace, name string) error {
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().DestinationRules(namespace)
+	dr, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(countIstioError("get", "DestinationRule", err))
+	}
+	if !IsManaged(dr) {
+		klog.Warningf("skip deleting unmanaged destinationrule %s.%s", namespace, name)
+		return nil
+	}
+	if mgr.cfg.DryRun {
+		return dryRunDelete("DestinationRule", namespace, name)
+	}
+	mgr.expectDelete(dr.UID)
+	err = client.Delete(context.Background(), name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(dr.UID)),
+	})
+	if err != nil {
+		mgr.forgetDelete(dr.UID)
+	}
+	return ignoreNotFound(countIstioError("delete", "DestinationRule", err))
+}
+
+// deleteVirtualService deletes the managed virtual service, a missing or unmanaged one is left alone
+func (mgr *AutoGwManager) deleteVirtualService(namespace, name string) error {
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().VirtualServices(namespace)
+	vs, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(countIstioError("get", "VirtualService", err))
+	}
+	if !IsManaged(vs) {
+		klog.Warningf("skip deleting unmanaged virtualservice %s.%s", namespace, name)
+		return nil
+	}
+	if mgr.cfg.DryRun {
+		return dryRunDelete("VirtualService", namespace, name)
+	}
+	mgr.expectDelete(vs.UID)
+	err = client.Delete(context.Background(), name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(vs.UID)),
+	})
+	if err != nil {
+		mgr.forgetDelete(vs.UID)
+	}
+	return ignoreNotFound(countIstioError("delete", "VirtualService", err))
+}
+
+// deleteGateway deletes the managed gateway, a missing or unmanaged one is left alone
+func (mgr *AutoGwManager) deleteGateway(namespace, name string) error {
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3().Gateways(namespace)
+	gw, err := client.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(countIstioError("get", "Gateway", err))
+	}
+	if !IsManaged(gw) {
+		klog.Warningf("skip deleting unmanaged gateway %s.%s", namespace, name)
+		return nil
+	}
+	if mgr.cfg.DryRun {
+		return dryRunDelete("Gateway", namespace, name)
+	}
+	mgr.expectDelete(gw.UID)
+	err = client.Delete(context.Background(), name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(gw.UID)),
+	})
+	if err != nil {
+		mgr.forgetDelete(gw.UID)
+	}
+	return ignoreNotFound(countIstioError("delete", "Gateway", err))
+}
+
+// countIstioError counts a failed istio API request and returns err,
+// NotFound is an expected answer and not counted
+func countIstioError(verb, kind string, err error) error {
+	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.IstioAPIErrors.WithLabelValues(verb, kind).Inc()
+	}
+	return err
+}
+
+// ignoreNotFound returns nil for a NotFound error
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
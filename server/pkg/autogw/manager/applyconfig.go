@@ -0,0 +1,88 @@
+package manager
+
+import (
+	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+)
+
+// objectApplyConfiguration holds the type and object metadata of a generated object for use with
+// server-side apply. It only carries the metadata edge-auto-gw owns: name, namespace, the
+// managed-by label, the source annotations and the owner reference to the service.
+type objectApplyConfiguration struct {
+	metav1apply.TypeMetaApplyConfiguration    `json:",inline"`
+	*metav1apply.ObjectMetaApplyConfiguration `json:"metadata,omitempty"`
+}
+
+func newObjectApplyConfiguration(kind string, meta metav1.ObjectMeta) objectApplyConfiguration {
+	ac := objectApplyConfiguration{
+		ObjectMetaApplyConfiguration: metav1apply.ObjectMeta().
+			WithName(meta.Name).
+			WithNamespace(meta.Namespace).
+			WithLabels(meta.Labels).
+			WithAnnotations(meta.Annotations),
+	}
+	ac.WithKind(kind).WithAPIVersion(istioapi.SchemeGroupVersion.String())
+
+	for _, ref := range meta.OwnerReferences {
+		refAc := metav1apply.OwnerReference().
+			WithAPIVersion(ref.APIVersion).
+			WithKind(ref.Kind).
+			WithName(ref.Name).
+			WithUID(ref.UID)
+		if ref.Controller != nil {
+			refAc.WithController(*ref.Controller)
+		}
+		if ref.BlockOwnerDeletion != nil {
+			refAc.WithBlockOwnerDeletion(*ref.BlockOwnerDeletion)
+		}
+		ac.WithOwnerReferences(refAc)
+	}
+	return ac
+}
+
+// DestinationRuleApplyConfiguration represents a declarative configuration of a generated
+// DestinationRule for use with server-side apply
+type DestinationRuleApplyConfiguration struct {
+	objectApplyConfiguration
+	Spec *networkingv1alpha3.DestinationRule `json:"spec,omitempty"`
+}
+
+// DestinationRuleApplyConfigurationFor returns the apply configuration of a generated DestinationRule
+func DestinationRuleApplyConfigurationFor(dr *istioapi.DestinationRule) *DestinationRuleApplyConfiguration {
+	return &DestinationRuleApplyConfiguration{
+		objectApplyConfiguration: newObjectApplyConfiguration("DestinationRule", dr.ObjectMeta),
+		Spec:                     dr.Spec.DeepCopy(),
+	}
+}
+
+// VirtualServiceApplyConfiguration represents a declarative configuration of a generated
+// VirtualService for use with server-side apply
+type VirtualServiceApplyConfiguration struct {
+	objectApplyConfiguration
+	Spec *networkingv1alpha3.VirtualService `json:"spec,omitempty"`
+}
+
+// VirtualServiceApplyConfigurationFor returns the apply configuration of a generated VirtualService
+func VirtualServiceApplyConfigurationFor(vs *istioapi.VirtualService) *VirtualServiceApplyConfiguration {
+	return &VirtualServiceApplyConfiguration{
+		objectApplyConfiguration: newObjectApplyConfiguration("VirtualService", vs.ObjectMeta),
+		Spec:                     vs.Spec.DeepCopy(),
+	}
+}
+
+// GatewayApplyConfiguration represents a declarative configuration of a generated
+// Gateway for use with server-side apply
+type GatewayApplyConfiguration struct {
+	objectApplyConfiguration
+	Spec *networkingv1alpha3.Gateway `json:"spec,omitempty"`
+}
+
+// GatewayApplyConfigurationFor returns the apply configuration of a generated Gateway
+func GatewayApplyConfigurationFor(gw *istioapi.Gateway) *GatewayApplyConfiguration {
+	return &GatewayApplyConfiguration{
+		objectApplyConfiguration: newObjectApplyConfiguration("Gateway", gw.ObjectMeta),
+		Spec:                     gw.Spec.DeepCopy(),
+	}
+}
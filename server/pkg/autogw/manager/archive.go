@@ -0,0 +1,212 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	istio "istio.io/client-go/pkg/clientset/versioned"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+)
+
+const (
+	// ArchiveAPIVersion is the version of the exposure archive format
+	ArchiveAPIVersion = "edgeautogw.kubeedge.io/v1"
+	// ArchiveKind is the kind of the exposure archive
+	ArchiveKind = "ExposureArchive"
+)
+
+// Archive is a backup of the edge-auto-gw exposures of a cluster
+type Archive struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	ExportedAt metav1.Time        `json:"exportedAt"`
+	Exposures  []ArchivedExposure `json:"exposures"`
+}
+
+// ArchivedExposure is the gateway labels of a service and the objects rendered from them.
+// The objects document what was generated, a restore renders them again for the target
+// service, so they are owned by it.
+type ArchivedExposure struct {
+	Namespace       string                    `json:"namespace"`
+	Service         string                    `json:"service"`
+	Labels          map[string]string         `json:"labels"`
+	Gateway         *istioapi.Gateway         `json:"gateway"`
+	VirtualService  *istioapi.VirtualService  `json:"virtualService"`
+	DestinationRule *istioapi.DestinationRule `json:"destinationRule"`
+}
+
+// GatewayLabels returns the gateway labels of a label set, the labels an exposure is rendered from
+func GatewayLabels(l map[string]string) map[string]string {
+	gl := make(map[string]string)
+	for _, key := range []string{controller.LabelEdgemeshGatewayProtocols, controller.LabelEdgemeshGatewayPort} {
+		if v, ok := l[key]; ok {
+			gl[key] = v
+		}
+	}
+	return gl
+}
+
+// NewArchive archives the exposures of services, services with invalid labels are returned
+// as label errors instead
+func NewArchive(services []*v1.Service) (*Archive, []LabelError) {
+	archive := &Archive{
+		APIVersion: ArchiveAPIVersion,
+		Kind:       ArchiveKind,
+		ExportedAt: metav1.Now(),
+		Exposures:  make([]ArchivedExposure, 0, len(services)),
+	}
+
+	var labelErrors []LabelError
+	for _, svc := range services {
+		if svc.GetDeletionTimestamp() != nil {
+			continue
+		}
+		exposure, err := RenderExposure(svc)
+		if err != nil {
+			labelErrors = append(labelErrors, LabelError{Name: svc.Namespace + "/" + svc.Name, Error: err.Error()})
+			continue
+		}
+
+		gv := istioapi.SchemeGroupVersion.String()
+		exposure.Gateway.APIVersion, exposure.Gateway.Kind = gv, "Gateway"
+		exposure.VirtualService.APIVersion, exposure.VirtualService.Kind = gv, "VirtualService"
+		exposure.DestinationRule.APIVersion, exposure.DestinationRule.Kind = gv, "DestinationRule"
+		archive.Exposures = append(archive.Exposures, ArchivedExposure{
+			Namespace:       svc.Namespace,
+			Service:         svc.Name,
+			Labels:          GatewayLabels(svc.GetLabels()),
+			Gateway:         exposure.Gateway,
+			VirtualService:  exposure.VirtualService,
+			DestinationRule: exposure.DestinationRule,
+		})
+	}
+
+	sort.Slice(archive.Exposures, func(i, j int) bool {
+		a, b := archive.Exposures[i], archive.Exposures[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Service < b.Service
+	})
+	return archive, labelErrors
+}
+
+// Validate checks the archive is of a format this version can restore
+func (a *Archive) Validate() error {
+	if a.Kind != ArchiveKind {
+		return fmt.Errorf("not an exposure archive, kind is %q instead of %s", a.Kind, ArchiveKind)
+	}
+	if a.APIVersion != ArchiveAPIVersion {
+		return fmt.Errorf("unsupported archive version %q, this edge-auto-gw reads %s", a.APIVersion, ArchiveAPIVersion)
+	}
+	for i, e := range a.Exposures {
+		if e.Namespace == "" || e.Service == "" {
+			return fmt.Errorf("exposure %d has no namespace or service", i)
+		}
+		if len(e.Labels) == 0 {
+			return fmt.Errorf("exposure %s/%s has no gateway labels", e.Namespace, e.Service)
+		}
+	}
+	return nil
+}
+
+// restoreChange is an object RestoreObjects writes, force takes over an adopted object
+type restoreChange struct {
+	desired planObject
+	item    PlanItem
+	force   bool
+}
+
+// RestoreObjects applies the objects of exposure with the edge-auto-gw field manager, for restoring
+// into a cluster where the controller does not generate them. It returns the changes. An object
+// in the way of a generated one is reported as a conflict unless adopt is set, then nothing of the
+// exposure is written, so the gateway is never published without its routes. Nothing is written
+// with dryRun.
+func RestoreObjects(ctx context.Context, client istio.Interface, exposure *Exposure, adopt, dryRun bool) ([]PlanItem, error) {
+	networking := client.NetworkingV1alpha3()
+
+	// every object is checked before any is written
+	var changes []restoreChange
+	var conflicts []PlanItem
+	for _, desired := range renderedObjects(exposure) {
+		namespace, name := desired.meta.GetNamespace(), desired.meta.GetName()
+
+		var live planObject
+		var err error
+		switch obj := desired.meta.(type) {
+		case *istioapi.DestinationRule:
+			var dr *istioapi.DestinationRule
+			if dr, err = networking.DestinationRules(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+				live = planObject{kind: desired.kind, meta: dr, spec: &dr.Spec, ac: DestinationRuleApplyConfigurationFor(dr)}
+			}
+			desired.ac = DestinationRuleApplyConfigurationFor(obj)
+		case *istioapi.VirtualService:
+			var vs *istioapi.VirtualService
+			if vs, err = networking.VirtualServices(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+				live = planObject{kind: desired.kind, meta: vs, spec: &vs.Spec, ac: VirtualServiceApplyConfigurationFor(vs)}
+			}
+			desired.ac = VirtualServiceApplyConfigurationFor(obj)
+		case *istioapi.Gateway:
+			var gw *istioapi.Gateway
+			if gw, err = networking.Gateways(namespace).Get(ctx, name, metav1.GetOptions{}); err == nil {
+				live = planObject{kind: desired.kind, meta: gw, spec: &gw.Spec, ac: GatewayApplyConfigurationFor(gw)}
+			}
+			desired.ac = GatewayApplyConfigurationFor(obj)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("get %s %s.%s failed: %w", desired.kind, namespace, name, err)
+		}
+
+		item, changed := planObjectChange(desired, live, adopt)
+		switch {
+		case !changed:
+		case item.Action == PlanConflict:
+			conflicts = append(conflicts, item)
+		default:
+			// an adopted object was written by another field manager
+			force := live.meta != nil && !IsManaged(live.meta)
+			changes = append(changes, restoreChange{desired: desired, item: item, force: force})
+		}
+	}
+	if len(conflicts) > 0 {
+		return conflicts, nil
+	}
+
+	// the destinationrule and the virtualservice are written before the gateway publishes them
+	var items []PlanItem
+	for _, change := range changes {
+		if dryRun {
+			items = append(items, change.item)
+			continue
+		}
+		desired := change.desired
+		namespace, name := desired.meta.GetNamespace(), desired.meta.GetName()
+		// the apply configuration is taken after the spec hash was stamped, so it is written too
+		data, err := json.Marshal(desired.ac)
+		if err != nil {
+			return items, fmt.Errorf("marshal %s apply configuration failed: %v", desired.kind, err)
+		}
+		opts := metav1.PatchOptions{FieldManager: FieldManager, Force: &change.force}
+		switch desired.kind {
+		case "DestinationRule":
+			_, err = networking.DestinationRules(namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+		case "VirtualService":
+			_, err = networking.VirtualServices(namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+		case "Gateway":
+			_, err = networking.Gateways(namespace).Patch(ctx, name, types.ApplyPatchType, data, opts)
+		}
+		if err != nil {
+			return items, fmt.Errorf("apply %s %s.%s failed: %w", desired.kind, namespace, name, err)
+		}
+		items = append(items, change.item)
+	}
+	return items, nil
+}
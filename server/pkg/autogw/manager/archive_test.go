@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRestoreObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		objs   []runtime.Object
+		adopt  bool
+		dryRun bool
+		// want maps kind to the returned action
+		want map[string]PlanAction
+		// wantObjects are the objects of the service which exist after the restore
+		wantObjects int
+	}{
+		{
+			name:        "nothing exists",
+			want:        map[string]PlanAction{"DestinationRule": PlanCreate, "VirtualService": PlanCreate, "Gateway": PlanCreate},
+			wantObjects: 3,
+		},
+		{
+			name:        "dry-run writes nothing",
+			dryRun:      true,
+			want:        map[string]PlanAction{"DestinationRule": PlanCreate, "VirtualService": PlanCreate, "Gateway": PlanCreate},
+			wantObjects: 0,
+		},
+		{
+			name:        "unmanaged virtualservice skips the exposure",
+			objs:        []runtime.Object{&istioapi.VirtualService{ObjectMeta: unmanagedGateway("web").ObjectMeta}},
+			want:        map[string]PlanAction{"VirtualService": PlanConflict},
+			wantObjects: 1,
+		},
+		{
+			name:        "unmanaged destinationrule skips the exposure",
+			objs:        []runtime.Object{&istioapi.DestinationRule{ObjectMeta: unmanagedGateway("web").ObjectMeta}},
+			want:        map[string]PlanAction{"DestinationRule": PlanConflict},
+			wantObjects: 1,
+		},
+		{
+			name:        "unmanaged virtualservice adopted",
+			objs:        []runtime.Object{&istioapi.VirtualService{ObjectMeta: unmanagedGateway("web").ObjectMeta}},
+			adopt:       true,
+			want:        map[string]PlanAction{"DestinationRule": PlanCreate, "VirtualService": PlanUpdate, "Gateway": PlanCreate},
+			wantObjects: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newIstioClient(t, tt.objs...)
+			exposure, err := RenderExposure(labeledService("web", "80-30080"))
+			if err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+
+			items, err := RestoreObjects(context.Background(), client, exposure, tt.adopt, tt.dryRun)
+			if err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+			got := make(map[string]PlanAction)
+			for _, item := range items {
+				got[item.Kind] = item.Action
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("restore returned %v, want %v", got, tt.want)
+			}
+			for kind, action := range tt.want {
+				if got[kind] != action {
+					t.Errorf("%s restored as %q, want %q", kind, got[kind], action)
+				}
+			}
+			if objects := existing(t, client, "web"); len(objects) != tt.wantObjects {
+				t.Errorf("%v exist after the restore, want %d objects", objects, tt.wantObjects)
+			}
+		})
+	}
+}
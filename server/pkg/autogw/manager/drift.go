@@ -0,0 +1,103 @@
+package manager
+
+import (
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
+)
+
+// watchGeneratedObjects registers istio informers for gateways, virtualservices and
+// destinationrules, so that managed objects edited or deleted by others are restored
+// and unchanged objects are not written again
+func (mgr *AutoGwManager) watchGeneratedObjects() {
+	networking := mgr.ifm.GetIstioFactory().Networking().V1alpha3()
+	mgr.gwLister = networking.Gateways().Lister()
+	mgr.vsLister = networking.VirtualServices().Lister()
+	mgr.drLister = networking.DestinationRules().Lister()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) { mgr.onGeneratedObjectChanged(newObj) },
+		DeleteFunc: mgr.onGeneratedObjectDeleted,
+	}
+	networking.Gateways().Informer().AddEventHandler(handler)
+	networking.VirtualServices().Informer().AddEventHandler(handler)
+	networking.DestinationRules().Informer().AddEventHandler(handler)
+}
+
+// onGeneratedObjectChanged queues the source service of a changed or deleted managed object,
+// the service is re-rendered and the object restored if it no longer matches
+func (mgr *AutoGwManager) onGeneratedObjectChanged(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := apimeta.Accessor(obj)
+	if err != nil {
+		klog.Errorf("invalid type %v", obj)
+		return
+	}
+	if !IsManaged(accessor) {
+		return
+	}
+
+	key := accessor.GetNamespace() + "/" + accessor.GetName()
+	mgr.driftLock.Lock()
+	mgr.driftKeys.Insert(key)
+	mgr.driftLock.Unlock()
+	mgr.services.Enqueue(key)
+}
+
+// onGeneratedObjectDeleted handles the delete of a managed object like a change, unless the
+// manager deleted it itself. A rollback delete queued as drift would skip the rate limiter
+// and create and roll back the objects of a failing exposure without end.
+func (mgr *AutoGwManager) onGeneratedObjectDeleted(obj interface{}) {
+	deleted := obj
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		deleted = tombstone.Obj
+	}
+	if accessor, err := apimeta.Accessor(deleted); err == nil {
+		mgr.driftLock.Lock()
+		own := mgr.deletedUIDs.Has(string(accessor.GetUID()))
+		mgr.deletedUIDs.Delete(string(accessor.GetUID()))
+		mgr.driftLock.Unlock()
+		if own {
+			return
+		}
+	}
+	mgr.onGeneratedObjectChanged(obj)
+}
+
+// expectDelete records that the manager deletes the generated object uid
+func (mgr *AutoGwManager) expectDelete(uid types.UID) {
+	mgr.driftLock.Lock()
+	mgr.deletedUIDs.Insert(string(uid))
+	mgr.driftLock.Unlock()
+}
+
+// forgetDelete drops the record of a delete which failed
+func (mgr *AutoGwManager) forgetDelete(uid types.UID) {
+	mgr.driftLock.Lock()
+	mgr.deletedUIDs.Delete(string(uid))
+	mgr.driftLock.Unlock()
+}
+
+// takeDrift reports and clears whether a managed object of the service key was changed by others
+func (mgr *AutoGwManager) takeDrift(key string) bool {
+	mgr.driftLock.Lock()
+	defer mgr.driftLock.Unlock()
+	drifted := mgr.driftKeys.Has(key)
+	mgr.driftKeys.Delete(key)
+	return drifted
+}
+
+// reportCorrection logs and counts a drifted object which was written back,
+// reclaimed objects are already counted when their event is recorded
+func reportCorrection(kind, namespace, name string, result applyResult) {
+	if result == resultUnchanged || result == resultDryRun || result == resultReclaimed {
+		return
+	}
+	klog.Infof("restored drifted %s %s.%s, %s", kind, namespace, name, result)
+	metrics.DriftCorrections.WithLabelValues(kind).Inc()
+}
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// resultDryRun indicates the object was only rendered and diffed because of dry-run
+const resultDryRun applyResult = "dry-run"
+
+// diffView is the part of a generated object edge-auto-gw owns, live objects are trimmed
+// to it so the labels and annotations of other tools do not show up in the diff
+type diffView struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Spec        interface{}       `json:"spec"`
+}
+
+// ownedView returns the view of an object with meta and spec, limited to the labels and
+// annotations set on the rendered object owned
+func ownedView(meta, owned metav1.Object, spec interface{}) diffView {
+	view := diffView{Spec: spec}
+	for k := range owned.GetLabels() {
+		if v, ok := meta.GetLabels()[k]; ok {
+			if view.Labels == nil {
+				view.Labels = make(map[string]string)
+			}
+			view.Labels[k] = v
+		}
+	}
+	for k := range owned.GetAnnotations() {
+		if v, ok := meta.GetAnnotations()[k]; ok {
+			if view.Annotations == nil {
+				view.Annotations = make(map[string]string)
+			}
+			view.Annotations[k] = v
+		}
+	}
+	return view
+}
+
+// yamlLines renders v as YAML lines for diffing
+func yamlLines(v interface{}) []string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return []string{"<render failed: " + err.Error() + ">"}
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// dryRunApply logs the diff applying the rendered object desired would make to the live
+// object instead of writing it, live is nil if it does not exist yet
+func (mgr *AutoGwManager) dryRunApply(kind string, desired metav1.Object, desiredSpec interface{}, live metav1.Object, liveSpec interface{}) (applyResult, error) {
+	if live != nil {
+		if err := mgr.checkOwnership(kind, live); err != nil {
+			return "", err
+		}
+	}
+
+	after := ownedView(desired, desired, desiredSpec)
+	if live == nil {
+		klog.Infof("dry-run: would create %s %s.%s:\n%s", kind, desired.GetNamespace(), desired.GetName(),
+			strings.Join(yamlLines(after), "\n"))
+		return resultDryRun, nil
+	}
+	klog.Infof("dry-run: would update %s %s.%s (-live +rendered):\n%s", kind, desired.GetNamespace(), desired.GetName(),
+		objectDiff(live, liveSpec, desired, desiredSpec))
+	return resultDryRun, nil
+}
+
+// objectDiff returns the diff between the part of the live object edge-auto-gw owns and the rendered object
+func objectDiff(live metav1.Object, liveSpec interface{}, desired metav1.Object, desiredSpec interface{}) string {
+	before := ownedView(live, desired, liveSpec)
+	after := ownedView(desired, desired, desiredSpec)
+	return cmp.Diff(yamlLines(before), yamlLines(after))
+}
+
+// dryRunDelete logs the managed object which would be deleted
+func dryRunDelete(kind, namespace, name string) error {
+	klog.Infof("dry-run: would delete %s %s.%s", kind, namespace, name)
+	return nil
+}
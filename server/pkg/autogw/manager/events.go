@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
+)
+
+// Reasons of the events recorded on the source service, tenants see them with kubectl describe
+const (
+	// EventExposed is recorded when the gateway of the service is created
+	EventExposed = "Exposed"
+	// EventUpdated is recorded when generated objects of the service are changed
+	EventUpdated = "Updated"
+	// EventWithdrawn is recorded when the generated objects of the service are deleted
+	EventWithdrawn = "Withdrawn"
+	// EventInvalidLabels is recorded when the gateway labels of the service can not be parsed
+	EventInvalidLabels = "InvalidLabels"
+	// EventApplyFailed is recorded when the generated objects of the service can not be written
+	EventApplyFailed = "ApplyFailed"
+	// EventFieldsReclaimed is recorded when fields of a generated object which another field
+	// manager changed are forced back
+	EventFieldsReclaimed = "FieldsReclaimed"
+)
+
+// eventComponent is the source of the recorded events
+const eventComponent = "edge-auto-gw"
+
+// newEventRecorder returns a recorder which writes events through client,
+// in dry-run the events are dropped
+func newEventRecorder(client kubernetes.Interface, dryRun bool) record.EventRecorder {
+	if dryRun {
+		return &record.FakeRecorder{}
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartStructuredLogging(4)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+}
+
+// recordApplied records what applying the generated objects did to the exposure of svc
+func (mgr *AutoGwManager) recordApplied(svc *v1.Service, gw, vs, dr applyResult, ports []uint32) {
+	switch {
+	case gw == resultCreated:
+		mgr.recorder.Eventf(svc, v1.EventTypeNormal, EventExposed,
+			"exposed on edgemesh-gateway ports %v", ports)
+	case gw != resultUnchanged || vs != resultUnchanged || dr != resultUnchanged:
+		mgr.recorder.Eventf(svc, v1.EventTypeNormal, EventUpdated,
+			"updated gateway %s, virtualservice %s, destinationrule %s", gw, vs, dr)
+	}
+	for _, obj := range []struct {
+		kind   string
+		result applyResult
+	}{{"Gateway", gw}, {"VirtualService", vs}, {"DestinationRule", dr}} {
+		if obj.result != resultReclaimed {
+			continue
+		}
+		mgr.recorder.Eventf(svc, v1.EventTypeWarning, EventFieldsReclaimed,
+			"took back the %s fields changed by another field manager", strings.ToLower(obj.kind))
+		metrics.DriftCorrections.WithLabelValues(obj.kind).Inc()
+	}
+}
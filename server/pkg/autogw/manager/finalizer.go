@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// FinalizerCleanup holds an exposed service until its generated objects are deleted
+const FinalizerCleanup = "kubeedge.io/edge-auto-gw-cleanup"
+
+// hasFinalizer reports whether svc is held by edge-auto-gw
+func hasFinalizer(svc *v1.Service) bool {
+	for _, f := range svc.GetFinalizers() {
+		if f == FinalizerCleanup {
+			return true
+		}
+	}
+	return false
+}
+
+// finalizerPatch returns a strategic merge patch which adds or removes FinalizerCleanup,
+// the uid makes sure a recreated service with the same name is left alone
+func finalizerPatch(svc *v1.Service, add bool) ([]byte, error) {
+	key := "finalizers"
+	if !add {
+		key = "$deleteFromPrimitiveList/finalizers"
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid": svc.GetUID(),
+			key:   []string{FinalizerCleanup},
+		},
+	})
+}
+
+// ensureFinalizer adds FinalizerCleanup to the service before anything is generated for it,
+// so deleting the service waits until the generated objects are gone
+func (mgr *AutoGwManager) ensureFinalizer(svc *v1.Service) error {
+	if hasFinalizer(svc) || mgr.cfg.DryRun {
+		return nil
+	}
+	data, err := finalizerPatch(svc, true)
+	if err != nil {
+		return err
+	}
+	_, err = mgr.ifm.GetKubeClient().CoreV1().Services(svc.Namespace).Patch(context.Background(),
+		svc.Name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("add finalizer to service %s.%s failed: %w", svc.Namespace, svc.Name, err)
+	}
+	return nil
+}
+
+// removeFinalizer releases the service once its generated objects are deleted
+func (mgr *AutoGwManager) removeFinalizer(svc *v1.Service) error {
+	if !hasFinalizer(svc) || mgr.cfg.DryRun {
+		return nil
+	}
+	data, err := finalizerPatch(svc, false)
+	if err != nil {
+		return err
+	}
+	_, err = mgr.ifm.GetKubeClient().CoreV1().Services(svc.Namespace).Patch(context.Background(),
+		svc.Name, types.StrategicMergePatchType, data, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("remove finalizer from service %s.%s failed: %w", svc.Namespace, svc.Name, err)
+	}
+	klog.V(4).Infof("released service %s.%s", svc.Namespace, svc.Name)
+	return nil
+}
+
+// withdraw releases a service whose generated objects were deleted, a service which was
+// exposed is told so with an event
+func (mgr *AutoGwManager) withdraw(svc *v1.Service) error {
+	if !hasFinalizer(svc) {
+		return nil
+	}
+	if err := mgr.removeFinalizer(svc); err != nil {
+		return err
+	}
+	mgr.recorder.Event(svc, v1.EventTypeNormal, EventWithdrawn, "removed from edgemesh-gateway")
+	return nil
+}
+
+// releaseService withdraws a service which is no longer in the informer cache because its
+// gateway labels were removed. The cache only holds labeled services, so the service is
+// read from the API server.
+func (mgr *AutoGwManager) releaseService(namespace, name string) error {
+	svc, err := mgr.ifm.GetKubeClient().CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return ignoreNotFound(err)
+	}
+	if err := mgr.clearStatus(svc); err != nil {
+		return err
+	}
+	return mgr.withdraw(svc)
+}
@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"sort"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+)
+
+// Health tells whether the generated objects of a service match its labels
+type Health string
+
+const (
+	// HealthInSync means all generated objects exist and match the rendering
+	HealthInSync Health = "in-sync"
+	// HealthDrifted means a generated object differs from the rendering
+	HealthDrifted Health = "drifted"
+	// HealthMissing means a generated object does not exist or is not managed by edge-auto-gw
+	HealthMissing Health = "missing"
+	// HealthInvalidLabels means the gateway labels of the service can not be parsed
+	HealthInvalidLabels Health = "invalid-labels"
+)
+
+// InventoryEntry is one edge-auto-gw exposure: a service port published on a gateway port
+type InventoryEntry struct {
+	Namespace       string            `json:"namespace"`
+	Service         string            `json:"service"`
+	ServicePort     uint32            `json:"servicePort,omitempty"`
+	GatewayPort     uint32            `json:"gatewayPort,omitempty"`
+	Protocol        string            `json:"protocol,omitempty"`
+	GatewaySelector map[string]string `json:"gatewaySelector,omitempty"`
+	Health          Health            `json:"health"`
+	// Error is the label error of a service with invalid labels
+	Error string `json:"error,omitempty"`
+}
+
+// BuildInventory joins the services of state with their generated objects, one entry per
+// exposed port. The health of a service is decided like the controller would: an object
+// which is missing is worse than one which drifted.
+func BuildInventory(state *ClusterState, adopt bool) []InventoryEntry {
+	live := state.liveObjects()
+
+	var entries []InventoryEntry
+	for _, svc := range state.Services {
+		if svc.GetDeletionTimestamp() != nil {
+			continue
+		}
+		exposure, err := RenderExposure(svc)
+		if err != nil {
+			entries = append(entries, InventoryEntry{
+				Namespace: svc.Namespace, Service: svc.Name, Health: HealthInvalidLabels, Error: err.Error(),
+			})
+			continue
+		}
+
+		health := HealthInSync
+		for _, desired := range renderedObjects(exposure) {
+			lv := live[objectKey(desired.kind, desired.meta)]
+			if lv.meta == nil || (!IsManaged(lv.meta) && !adopt) {
+				health = HealthMissing
+				break
+			}
+			if _, changed := planObjectChange(desired, lv, adopt); changed {
+				health = HealthDrifted
+			}
+		}
+
+		selector := exposure.Gateway.Spec.Selector
+		if gw, ok := live[objectKey("Gateway", exposure.Gateway)].meta.(*istioapi.Gateway); ok {
+			selector = gw.Spec.Selector
+		}
+		for i := range exposure.Labels.GatewayPort {
+			entries = append(entries, InventoryEntry{
+				Namespace:       svc.Namespace,
+				Service:         svc.Name,
+				ServicePort:     exposure.Labels.ServicePort[i],
+				GatewayPort:     exposure.Labels.GatewayPort[i],
+				Protocol:        exposure.Labels.GateWayProtocol[i],
+				GatewaySelector: selector,
+				Health:          health,
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.GatewayPort < b.GatewayPort
+	})
+	return entries
+}
@@ -81,12 +81,12 @@ func (l Labels) extractLabels() (*LabelAnnotation, error) {
 
 		servicePort := cast.ToUint32(ports[0])
 		if ok := ValidateServicePort(servicePort); !ok {
-			return nil, fmt.Errorf("service port must >0 and < 65535", servicePort)
+			return nil, fmt.Errorf("service port %d must >0 and < 65535", servicePort)
 		}
 
 		gatewayPort := cast.ToUint32(ports[1])
 		if ok := ValidateGatewayPort(gatewayPort); !ok {
-			return nil, fmt.Errorf("gateway port must > 30000 and < 65535", gatewayPort)
+			return nil, fmt.Errorf("gateway port %d must > 30000 and < 65535", gatewayPort)
 		}
 
 		servicePortBox = append(servicePortBox, servicePort)
@@ -1,22 +1,28 @@
 package manager
 
 import (
-	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
 
 	networkingv1alpha3 "istio.io/api/networking/v1alpha3"
 	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1alpha3"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 
 	"github.com/yz271544/edge-auto-gw/server/common/constants"
 	"github.com/yz271544/edge-auto-gw/server/common/informers"
 	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/config"
 	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/metrics"
 )
 
 const (
@@ -24,232 +30,294 @@ const (
 	httpProtocol   = "HTTP"
 	minGatewayPort = 30000
 	maxGatewayPort = 65535
+
+	// LabelManagedBy marks the objects generated by edge-auto-gw, objects without it are never touched
+	LabelManagedBy = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the value of LabelManagedBy on generated objects
+	ManagedByValue = "edge-auto-gw"
+
+	// AnnotationSourceUID records the uid of the service a generated object was rendered from
+	AnnotationSourceUID = "kubeedge.io/edge-auto-gw-source-uid"
+	// AnnotationSpecHash records the hash of the rendered object, an object carrying the hash of
+	// the current rendering is not written again
+	AnnotationSpecHash = "kubeedge.io/edge-auto-gw-spec-hash"
 )
 
-// Manager is gateway manager
+// serviceSource looks up the labeled services and queues their keys, it is the service controller
+type serviceSource interface {
+	GetService(namespace, name string) (*v1.Service, error)
+	Enqueue(key string)
+}
+
+// Manager is gateway manager.
+// Services are reconciled concurrently, the work queue never hands the same
+// service key to two workers at once, so there is no manager wide lock.
 type AutoGwManager struct {
-	lock sync.Mutex
-	ifm  *informers.Manager
+	cfg      *config.EdgeAutoGwConfig
+	ifm      *informers.Manager
+	services serviceSource
+
+	gwLister istiolisters.GatewayLister
+	vsLister istiolisters.VirtualServiceLister
+	drLister istiolisters.DestinationRuleLister
+
+	// recorder records the exposure outcomes on the source services
+	recorder record.EventRecorder
+
+	// driftKeys holds the services whose managed objects were changed by others,
+	// deletedUIDs the generated objects the manager deleted itself, which are no drift
+	driftLock   sync.Mutex
+	driftKeys   sets.String
+	deletedUIDs sets.String
 }
 
 func NewAutoGwManager(c *config.EdgeAutoGwConfig, ifm *informers.Manager) *AutoGwManager {
 	mgr := &AutoGwManager{
-		ifm: ifm,
+		cfg:         c,
+		ifm:         ifm,
+		services:    controller.APIConn,
+		driftKeys:   sets.NewString(),
+		deletedUIDs: sets.NewString(),
+		recorder:    newEventRecorder(ifm.GetKubeClient(), c.DryRun),
 	}
 	klog.V(4).Infof("start get ips which need listen...")
-	// set edge-auto-gateway-manager event handler funcs
-	controller.APIConn.SetAutoGatewayEventHandlers("edge-auto-gateway-manager", cache.ResourceEventHandlerFuncs{
-		AddFunc: mgr.atAdd, UpdateFunc: mgr.atUpdate, DeleteFunc: mgr.atDelete})
+	// set edge-auto-gateway-manager reconcile func
+	controller.APIConn.SetReconcileFunc(mgr.reconcile)
+	controller.APIConn.IgnoreAnnotationChanges(AnnotationStatus)
+	mgr.watchGeneratedObjects()
+	metrics.RegisterExposureSource(mgr.listExposures)
 	return mgr
 }
 
-func (mgr *AutoGwManager) atAdd(obj interface{}) {
-	at, ok := obj.(*v1.Service)
-	if !ok {
-		klog.Errorf("invalid type %v", obj)
-		return
-	}
-	mgr.addAtGateway(at)
-}
-
-func (mgr *AutoGwManager) atUpdate(oldObj, newObj interface{}) {
-	at, ok := newObj.(*v1.Service)
-	if !ok {
-		klog.Errorf("invalid type %v", newObj)
-		return
-	}
-	mgr.updateAtGateway(at)
-}
-
-func (mgr *AutoGwManager) atDelete(obj interface{}) {
-	at, ok := obj.(*v1.Service)
-	if !ok {
-		klog.Errorf("invalid type %v", obj)
-		return
+// reconcile creates, updates or deletes the gateway resources of the service key
+func (mgr *AutoGwManager) reconcile(key string) error {
+	ns, nm, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		klog.Errorf("invalid service key %s: %v", key, err)
+		return nil
 	}
-	mgr.deleteAtGateway(at)
-}
-
-// addGateway add a gateway server
-func (mgr *AutoGwManager) addAtGateway(at *v1.Service) {
 
-	mgr.lock.Lock()
-	defer mgr.lock.Unlock()
-	var err error
-	if at == nil {
-		klog.Errorf("gateway is nil")
-		return
+	drifted := mgr.takeDrift(key)
+	at, err := mgr.services.GetService(ns, nm)
+	if apierrors.IsNotFound(err) {
+		// the service is deleted or no longer carries the gateway labels
+		if err := mgr.deleteAtGateway(ns, nm); err != nil {
+			return err
+		}
+		return mgr.releaseService(ns, nm)
 	}
-
-	labelAn, err := Labels(at.GetLabels()).extractLabels()
 	if err != nil {
-		klog.Errorf("get labels extract %s", err)
-		return
+		return err
 	}
 
-	ns := at.GetNamespace()
-	nm := at.GetName()
-
-	dr := GenerateDestinationRule(nm, ns)
-
-	vs := GenerateVirtualService(nm, ns, labelAn.GateWayProtocol, labelAn.ServicePort)
-
-	gw := GenerateGateway(nm, ns, labelAn.GateWayProtocol, labelAn.GatewayPort)
-
-	if dr == nil {
-		klog.Errorf("auto add %s.%s failed, dr is nil", ns, nm)
-		return
+	if at.GetDeletionTimestamp() != nil {
+		// the finalizer holds the service until its objects are confirmed gone
+		if err := mgr.deleteAtGateway(ns, nm); err != nil {
+			return err
+		}
+		return mgr.withdraw(at)
 	}
-	if vs == nil {
-		klog.Errorf("auto add %s.%s failed, vs is nil", ns, nm)
-		return
+
+	if err := mgr.ensureFinalizer(at); err != nil {
+		return err
 	}
-	if gw == nil {
-		klog.Errorf("auto add %s.%s failed, gw is nil", ns, nm)
-		return
+	err = mgr.applyAtGateway(at, drifted)
+	if drifted && err != nil {
+		// keep the drift until the objects are restored
+		mgr.driftLock.Lock()
+		mgr.driftKeys.Insert(key)
+		mgr.driftLock.Unlock()
 	}
-	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3()
-
-	_, err = client.DestinationRules(ns).Create(context.Background(), dr, metav1.CreateOptions{})
 	if err != nil {
-		klog.Errorf("create destination rule failed: %v", err)
-		return
+		mgr.recorder.Eventf(at, v1.EventTypeWarning, EventApplyFailed, "%v", err)
 	}
-
-	_, err = client.VirtualServices(ns).Create(context.Background(), vs, metav1.CreateOptions{})
-	if err != nil {
-		klog.Errorf("create virtualservice rule failed: %v", err)
-		return
+	if statusErr := mgr.updateStatus(at, exposureStatusOf(at, err)); statusErr != nil {
+		klog.Errorf("update status of %s failed: %v", key, statusErr)
+		if err == nil {
+			err = statusErr
+		}
 	}
-	_, err = client.Gateways(ns).Create(context.Background(), gw, metav1.CreateOptions{})
-	if err != nil {
-		klog.Errorf("create gateway rule failed: %v", err)
-		return
+	if IsOwnershipConflict(err) {
+		// retrying does not help until the conflicting object is removed or adopted
+		klog.Errorf("auto apply %s failed: %v", key, err)
+		return nil
 	}
-	klog.Infof("have created the gateway vs dr %s", nm)
+	return err
 }
 
-// updateGateway update a gateway server
-func (mgr *AutoGwManager) updateAtGateway(at *v1.Service) {
-	mgr.lock.Lock()
-	defer mgr.lock.Unlock()
-
-	var err error
+// applyAtGateway creates or updates the gateway server of the service,
+// drifted indicates that the generated objects were changed by others
+func (mgr *AutoGwManager) applyAtGateway(at *v1.Service, drifted bool) (err error) {
 	if at == nil {
 		klog.Errorf("gateway is nil")
-		return
+		return nil
 	}
 
-	labelAn, err := Labels(at.GetLabels()).extractLabels()
+	exposure, err := RenderExposure(at)
 	if err != nil {
+		// retrying does not help until the labels of the service are fixed
 		klog.Errorf("get labels extract %s", err)
-		return
+		metrics.LabelParseFailures.WithLabelValues(at.GetNamespace()).Inc()
+		mgr.recorder.Eventf(at, v1.EventTypeWarning, EventInvalidLabels, "invalid gateway labels: %v", err)
+		return nil
 	}
 
 	ns := at.GetNamespace()
 	nm := at.GetName()
+	dr, vs, gw := exposure.DestinationRule, exposure.VirtualService, exposure.Gateway
 
-	dr := GenerateDestinationRule(nm, ns)
+	// an unmanaged object in the way stops the exposure before anything is written,
+	// a conflict on the gateway must not create and roll back the routes on every retry
+	if err := mgr.checkExposureOwnership(exposure); err != nil {
+		return err
+	}
 
-	vs := GenerateVirtualService(nm, ns, labelAn.GateWayProtocol, labelAn.ServicePort)
+	// The exposure is applied as a unit: the gateway is published last, so edgemesh-gateway
+	// never opens a port without routes behind it, and objects created by a failed attempt
+	// are rolled back before the whole exposure is retried.
+	var rollback []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(rollback) - 1; i >= 0; i-- {
+			if rbErr := rollback[i](); rbErr != nil {
+				klog.Errorf("rollback %s.%s failed: %v", ns, nm, rbErr)
+			}
+		}
+	}()
 
-	gw := GenerateGateway(nm, ns, labelAn.GateWayProtocol, labelAn.GatewayPort)
-	if dr == nil {
-		klog.Errorf("auto update %s.%s failed, dr is nil", ns, nm)
-		return
-	}
-	if vs == nil {
-		klog.Errorf("auto update %s.%s failed, vs is nil", ns, nm)
-		return
+	drResult, err := mgr.applyDestinationRule(dr)
+	if err != nil {
+		return fmt.Errorf("apply destination rule failed: %w", err)
 	}
-	if gw == nil {
-		klog.Errorf("auto update %s.%s failed, gw is nil", ns, nm)
-		return
+	if drResult == resultCreated {
+		rollback = append(rollback, func() error { return mgr.deleteDestinationRule(ns, nm) })
 	}
-	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3()
 
-	_, err = client.DestinationRules(ns).Update(context.Background(), dr, metav1.UpdateOptions{})
+	vsResult, err := mgr.applyVirtualService(vs)
 	if err != nil {
-		klog.Errorf("update destination rule failed: %v", err)
-		return
+		return fmt.Errorf("apply virtualservice rule failed: %w", err)
+	}
+	if vsResult == resultCreated {
+		rollback = append(rollback, func() error { return mgr.deleteVirtualService(ns, nm) })
 	}
 
-	_, err = client.VirtualServices(ns).Update(context.Background(), vs, metav1.UpdateOptions{})
+	gwResult, err := mgr.applyGateway(gw)
 	if err != nil {
-		klog.Errorf("update virtualservice rule failed: %v", err)
-		return
+		return fmt.Errorf("apply gateway rule failed: %w", err)
 	}
-	_, err = client.Gateways(ns).Update(context.Background(), gw, metav1.UpdateOptions{})
-	if err != nil {
-		klog.Errorf("update gateway rule failed: %v", err)
-		return
+	klog.Infof("have applied the gateway %s vs %s dr %s of %s.%s", gwResult, vsResult, drResult, ns, nm)
+	mgr.recordApplied(at, gwResult, vsResult, drResult, exposure.Labels.GatewayPort)
+	if drifted {
+		reportCorrection("DestinationRule", ns, nm, drResult)
+		reportCorrection("VirtualService", ns, nm, vsResult)
+		reportCorrection("Gateway", ns, nm, gwResult)
 	}
-	klog.Infof("have updated the gateway vs dr %s", nm)
+	return nil
 }
 
-// deleteGateway delete a gateway server
-func (mgr *AutoGwManager) deleteAtGateway(at *v1.Service) {
-	mgr.lock.Lock()
-	defer mgr.lock.Unlock()
+// Exposure holds the objects generated for one service
+type Exposure struct {
+	// Labels are the parsed gateway labels of the service
+	Labels          *LabelAnnotation
+	Gateway         *istioapi.Gateway
+	VirtualService  *istioapi.VirtualService
+	DestinationRule *istioapi.DestinationRule
+}
 
-	var err error
-	if at == nil {
-		klog.Errorf("gateway is nil")
-		return
+// RenderExposure parses the gateway labels of svc and renders its gateway, virtualservice and
+// destinationrule. It needs no cluster, the controller and the render command share it.
+func RenderExposure(svc *v1.Service) (*Exposure, error) {
+	labelAn, err := Labels(svc.GetLabels()).extractLabels()
+	if err != nil {
+		return nil, err
 	}
 
-	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3()
-
-	ns := at.GetNamespace()
-	nm := at.GetName()
-
-	dr, err := client.DestinationRules(ns).Get(context.Background(), nm, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("Get the DestinationRules:%s.%s failed", ns, nm)
+	dr := GenerateDestinationRule(svc)
+	vs := GenerateVirtualService(svc, labelAn.GateWayProtocol, labelAn.ServicePort)
+	gw := GenerateGateway(svc, labelAn.GateWayProtocol, labelAn.GatewayPort)
+	if dr == nil || vs == nil || gw == nil {
+		return nil, fmt.Errorf("render %s.%s failed", svc.GetNamespace(), svc.GetName())
 	}
-	if dr != nil {
-		err = client.DestinationRules(ns).Delete(context.Background(), nm, metav1.DeleteOptions{})
-		if err != nil {
-			klog.Errorf("delete destinationrule failed: %v", err)
-			return
-		}
+	return &Exposure{Labels: labelAn, Gateway: gw, VirtualService: vs, DestinationRule: dr}, nil
+}
+
+// deleteGateway delete a gateway server
+func (mgr *AutoGwManager) deleteAtGateway(ns, nm string) error {
+	// close the port on edgemesh-gateway before its routes go away
+	if err := mgr.deleteGateway(ns, nm); err != nil {
+		return fmt.Errorf("delete gateway failed: %w", err)
 	}
 
-	vs, err := client.VirtualServices(ns).Get(context.Background(), nm, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("Get the VirtualServices:%s.%s failed", ns, nm)
+	if err := mgr.deleteVirtualService(ns, nm); err != nil {
+		return fmt.Errorf("delete virtualservice failed: %w", err)
 	}
-	if vs != nil {
-		err = client.VirtualServices(ns).Delete(context.Background(), nm, metav1.DeleteOptions{})
-		if err != nil {
-			klog.Errorf("delete virtualservice failed: %v", err)
-			return
-		}
+
+	if err := mgr.deleteDestinationRule(ns, nm); err != nil {
+		return fmt.Errorf("delete destinationrule failed: %w", err)
 	}
 
-	gw, err := client.Gateways(ns).Get(context.Background(), nm, metav1.GetOptions{})
+	klog.Infof("have deleted the gateway vs dr %s", nm)
+	return nil
+}
+
+// listExposures returns the gateway servers of every managed gateway for the metrics
+func (mgr *AutoGwManager) listExposures() ([]metrics.Exposure, error) {
+	gws, err := mgr.gwLister.List(ManagedSelector())
 	if err != nil {
-		klog.Errorf("Get the Gateways:%s.%s failed", ns, nm)
-	}
-	if gw != nil {
-		err = client.Gateways(ns).Delete(context.Background(), nm, metav1.DeleteOptions{})
-		if err != nil {
-			klog.Errorf("delete gateway failed: %v", err)
-			return
+		return nil, err
+	}
+	exposures := make([]metrics.Exposure, 0, len(gws))
+	for _, gw := range gws {
+		e := metrics.Exposure{Namespace: gw.Namespace}
+		for _, server := range gw.Spec.Servers {
+			if server.Port == nil {
+				continue
+			}
+			e.Protocols = append(e.Protocols, server.Port.Protocol)
+			e.Ports = append(e.Ports, server.Port.Number)
 		}
+		exposures = append(exposures, e)
 	}
+	return exposures, nil
+}
 
-	klog.Infof("have deleted the gateway vs dr %s", nm)
+// ManagedSelector selects the objects generated by edge-auto-gw
+func ManagedSelector() labels.Selector {
+	return labels.SelectorFromSet(labels.Set{LabelManagedBy: ManagedByValue})
+}
+
+// IsManaged reports whether obj was generated by edge-auto-gw
+func IsManaged(obj metav1.Object) bool {
+	return obj.GetLabels()[LabelManagedBy] == ManagedByValue
+}
+
+// sourceObjectMeta returns the metadata of an object generated for the service svc. The
+// controller owner reference lets kubernetes garbage collect the object with the service,
+// the annotation traces the object back to the service it was rendered from.
+func sourceObjectMeta(svc *v1.Service) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      svc.GetName(),
+		Namespace: svc.GetNamespace(),
+		Labels: map[string]string{
+			LabelManagedBy: ManagedByValue,
+		},
+		Annotations: map[string]string{
+			AnnotationSourceUID: string(svc.GetUID()),
+		},
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(svc, v1.SchemeGroupVersion.WithKind("Service")),
+		},
+	}
 }
 
 // GenerateDestinationRule generate DestinationRule
-func GenerateDestinationRule(name, namespace string) (dr *istioapi.DestinationRule) {
+func GenerateDestinationRule(svc *v1.Service) (dr *istioapi.DestinationRule) {
+	name := svc.GetName()
 	dr = &istioapi.DestinationRule{
-		TypeMeta: metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
+		TypeMeta:   metav1.TypeMeta{},
+		ObjectMeta: sourceObjectMeta(svc),
 		Spec: networkingv1alpha3.DestinationRule{
 			Host: name,
 			TrafficPolicy: &networkingv1alpha3.TrafficPolicy{
@@ -264,7 +332,8 @@ func GenerateDestinationRule(name, namespace string) (dr *istioapi.DestinationRu
 	return
 }
 
-func GenerateVirtualService(name, namespace string, gatewayProtocols []string, svcPorts []uint32) (vs *istioapi.VirtualService) {
+func GenerateVirtualService(svc *v1.Service, gatewayProtocols []string, svcPorts []uint32) (vs *istioapi.VirtualService) {
+	name := svc.GetName()
 
 	tcpRoutes := make([]*networkingv1alpha3.TCPRoute, 0)
 	httpRoutes := make([]*networkingv1alpha3.HTTPRoute, 0)
@@ -311,11 +380,8 @@ func GenerateVirtualService(name, namespace string, gatewayProtocols []string, s
 	}
 
 	vs = &istioapi.VirtualService{
-		TypeMeta: metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
+		TypeMeta:   metav1.TypeMeta{},
+		ObjectMeta: sourceObjectMeta(svc),
 		Spec: networkingv1alpha3.VirtualService{
 			Hosts:    []string{"*"},
 			Gateways: []string{name},
@@ -327,7 +393,7 @@ func GenerateVirtualService(name, namespace string, gatewayProtocols []string, s
 	return
 }
 
-func GenerateGateway(name, namespace string, gatewayProtocols []string, gatewayPorts []uint32) (gw *istioapi.Gateway) {
+func GenerateGateway(svc *v1.Service, gatewayProtocols []string, gatewayPorts []uint32) (gw *istioapi.Gateway) {
 
 	servers := make([]*networkingv1alpha3.Server, 0)
 
@@ -346,11 +412,8 @@ func GenerateGateway(name, namespace string, gatewayProtocols []string, gatewayP
 	}
 
 	gw = &istioapi.Gateway{
-		TypeMeta: metav1.TypeMeta{},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
+		TypeMeta:   metav1.TypeMeta{},
+		ObjectMeta: sourceObjectMeta(svc),
 		Spec: networkingv1alpha3.Gateway{
 			Servers: servers,
 			Selector: map[string]string{
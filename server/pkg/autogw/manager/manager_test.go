@@ -0,0 +1,537 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	istiofake "istio.io/client-go/pkg/clientset/versioned/fake"
+	istiolisters "istio.io/client-go/pkg/listers/networking/v1alpha3"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yz271544/edge-auto-gw/server/common/informers"
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/config"
+)
+
+// fakeServices is a serviceSource serving fixed services and recording the queued keys
+type fakeServices struct {
+	svcs     map[string]*v1.Service
+	enqueued []string
+}
+
+func (f *fakeServices) GetService(namespace, name string) (*v1.Service, error) {
+	if svc, ok := f.svcs[namespace+"/"+name]; ok {
+		return svc, nil
+	}
+	return nil, apierrors.NewNotFound(v1.Resource("services"), name)
+}
+
+func (f *fakeServices) Enqueue(key string) {
+	f.enqueued = append(f.enqueued, key)
+}
+
+// applyReactor emulates server-side apply, which the fake istio clientset does not support:
+// the patch creates the object or replaces the existing one
+func applyReactor(client *istiofake.Clientset) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		var obj interface {
+			runtime.Object
+			metav1.Object
+		}
+		switch action.GetResource().Resource {
+		case "destinationrules":
+			obj = &istioapi.DestinationRule{}
+		case "virtualservices":
+			obj = &istioapi.VirtualService{}
+		case "gateways":
+			obj = &istioapi.Gateway{}
+		default:
+			return false, nil, nil
+		}
+		if err := json.Unmarshal(patch.GetPatch(), obj); err != nil {
+			return true, nil, err
+		}
+
+		gvr, ns := action.GetResource(), action.GetNamespace()
+		existing, err := client.Tracker().Get(gvr, ns, patch.GetName())
+		switch {
+		case apierrors.IsNotFound(err):
+			obj.SetUID(types.UID(fmt.Sprintf("%s-%s-uid", gvr.Resource, patch.GetName())))
+			obj.SetResourceVersion("1")
+			err = client.Tracker().Create(gvr, obj, ns)
+		case err == nil:
+			meta := existing.(metav1.Object)
+			obj.SetUID(meta.GetUID())
+			obj.SetResourceVersion(meta.GetResourceVersion() + "1")
+			err = client.Tracker().Update(gvr, obj, ns)
+		}
+		return true, obj, err
+	}
+}
+
+// newIstioClient returns a fake istio clientset holding objs which supports server-side apply
+func newIstioClient(t *testing.T, objs ...runtime.Object) *istiofake.Clientset {
+	client := istiofake.NewSimpleClientset()
+	client.PrependReactor("patch", "*", applyReactor(client))
+	// objects are created with their resource, the tracker guesses "gatewaies" for a Gateway
+	for _, obj := range objs {
+		var resource string
+		switch obj.(type) {
+		case *istioapi.Gateway:
+			resource = "gateways"
+		case *istioapi.VirtualService:
+			resource = "virtualservices"
+		case *istioapi.DestinationRule:
+			resource = "destinationrules"
+		}
+		gvr := istioapi.SchemeGroupVersion.WithResource(resource)
+		if err := client.Tracker().Create(gvr, obj, obj.(metav1.Object).GetNamespace()); err != nil {
+			t.Fatalf("create %v failed: %v", obj, err)
+		}
+	}
+	return client
+}
+
+// newTestManager returns a manager for the services svcs and the istio objects, the listers
+// hold the istio objects as well
+func newTestManager(t *testing.T, svcs []*v1.Service, objs ...runtime.Object) (*AutoGwManager, *istiofake.Clientset, *fakeServices) {
+	kubeObjs := make([]runtime.Object, 0, len(svcs))
+	services := &fakeServices{svcs: make(map[string]*v1.Service)}
+	for _, svc := range svcs {
+		kubeObjs = append(kubeObjs, svc)
+		services.svcs[svc.Namespace+"/"+svc.Name] = svc
+	}
+	istioClient := newIstioClient(t, objs...)
+
+	gwIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	vsIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	drIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		var err error
+		switch obj.(type) {
+		case *istioapi.Gateway:
+			err = gwIndexer.Add(obj)
+		case *istioapi.VirtualService:
+			err = vsIndexer.Add(obj)
+		case *istioapi.DestinationRule:
+			err = drIndexer.Add(obj)
+		}
+		if err != nil {
+			t.Fatalf("add %v to the lister failed: %v", obj, err)
+		}
+	}
+
+	mgr := &AutoGwManager{
+		cfg:         &config.EdgeAutoGwConfig{},
+		ifm:         informers.NewManagerForClients(kubefake.NewSimpleClientset(kubeObjs...), istioClient),
+		services:    services,
+		gwLister:    istiolisters.NewGatewayLister(gwIndexer),
+		vsLister:    istiolisters.NewVirtualServiceLister(vsIndexer),
+		drLister:    istiolisters.NewDestinationRuleLister(drIndexer),
+		recorder:    record.NewFakeRecorder(10),
+		driftKeys:   sets.NewString(),
+		deletedUIDs: sets.NewString(),
+	}
+	return mgr, istioClient, services
+}
+
+// existing returns which generated objects of default/name exist
+func existing(t *testing.T, client *istiofake.Clientset, name string) []string {
+	ctx := context.Background()
+	networking := client.NetworkingV1alpha3()
+	var kinds []string
+	check := func(kind string, err error) {
+		switch {
+		case err == nil:
+			kinds = append(kinds, kind)
+		case !apierrors.IsNotFound(err):
+			t.Fatalf("get %s failed: %v", kind, err)
+		}
+	}
+	_, err := networking.DestinationRules("default").Get(ctx, name, metav1.GetOptions{})
+	check("DestinationRule", err)
+	_, err = networking.VirtualServices("default").Get(ctx, name, metav1.GetOptions{})
+	check("VirtualService", err)
+	_, err = networking.Gateways("default").Get(ctx, name, metav1.GetOptions{})
+	check("Gateway", err)
+	return kinds
+}
+
+// events drains the events recorded by mgr
+func events(mgr *AutoGwManager) []string {
+	recorder := mgr.recorder.(*record.FakeRecorder)
+	var got []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			got = append(got, e)
+		default:
+			return got
+		}
+	}
+}
+
+func hasEvent(events []string, reason string) bool {
+	for _, e := range events {
+		if strings.Contains(e, " "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReconcileExposesService(t *testing.T) {
+	svc := labeledService("web", "80-30080")
+	mgr, client, _ := newTestManager(t, []*v1.Service{svc})
+
+	if err := mgr.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if got := existing(t, client, "web"); len(got) != 3 {
+		t.Errorf("generated %v, want all three objects", got)
+	}
+	live, err := mgr.ifm.GetKubeClient().CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service failed: %v", err)
+	}
+	if !hasFinalizer(live) {
+		t.Errorf("service is not held by the finalizer, finalizers are %v", live.Finalizers)
+	}
+	status := &ExposureStatus{}
+	if err := json.Unmarshal([]byte(live.Annotations[AnnotationStatus]), status); err != nil {
+		t.Fatalf("invalid status annotation: %v", err)
+	}
+	if status.LastError != "" || status.Gateway != "web" {
+		t.Errorf("status is %+v, want exposed without error", status)
+	}
+	if got := events(mgr); !hasEvent(got, EventExposed) {
+		t.Errorf("events are %v, want %s", got, EventExposed)
+	}
+}
+
+func TestReconcileFailedExposure(t *testing.T) {
+	tests := []struct {
+		name string
+		// objs are the istio objects in the cluster and the listers
+		objs []runtime.Object
+		// gatewayErr fails every write of the gateway
+		gatewayErr error
+		wantErr    bool
+		// wantObjects are the objects of the service which exist after the reconcile
+		wantObjects int
+	}{
+		{
+			name:        "unmanaged gateway in the way",
+			objs:        []runtime.Object{unmanagedGateway("web")},
+			wantErr:     false,
+			wantObjects: 1,
+		},
+		{
+			name:        "gateway write denied",
+			gatewayErr:  apierrors.NewForbidden(istioapi.Resource("gateways"), "web", errors.New("denied")),
+			wantErr:     true,
+			wantObjects: 0,
+		},
+		{
+			name:        "gateway rejected by a webhook",
+			gatewayErr:  apierrors.NewBadRequest("admission webhook denied the request"),
+			wantErr:     true,
+			wantObjects: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, client, services := newTestManager(t, []*v1.Service{labeledService("web", "80-30080")}, tt.objs...)
+			var deleted []runtime.Object
+			client.PrependReactor("delete", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				del := action.(k8stesting.DeleteAction)
+				obj, err := client.Tracker().Get(action.GetResource(), action.GetNamespace(), del.GetName())
+				if err == nil {
+					deleted = append(deleted, obj)
+				}
+				return false, nil, nil
+			})
+			if tt.gatewayErr != nil {
+				client.PrependReactor("patch", "gateways", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.gatewayErr
+				})
+			}
+
+			err := mgr.reconcile("default/web")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcile returned %v, want error %v", err, tt.wantErr)
+			}
+			if got := existing(t, client, "web"); len(got) != tt.wantObjects {
+				t.Errorf("%v exist after the failed exposure, want %d objects", got, tt.wantObjects)
+			}
+			if got := events(mgr); !hasEvent(got, EventApplyFailed) {
+				t.Errorf("events are %v, want %s", got, EventApplyFailed)
+			}
+
+			// the informer reports the rollback deletes, they must not queue the service again
+			for _, obj := range deleted {
+				mgr.onGeneratedObjectDeleted(obj)
+			}
+			if len(services.enqueued) != 0 || mgr.takeDrift("default/web") {
+				t.Errorf("rollback deletes of %d objects queued %v as drift", len(deleted), services.enqueued)
+			}
+		})
+	}
+}
+
+func TestOnGeneratedObjectDeleted(t *testing.T) {
+	managed := appliedState(t, labeledService("web", "80-30080")).Gateways[0]
+	managed.UID = "gateway-uid"
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		// ownDelete records the delete as one of the manager
+		ownDelete bool
+		want      []string
+	}{
+		{name: "deleted by others", obj: managed, want: []string{"default/web"}},
+		{name: "tombstone deleted by others", obj: cache.DeletedFinalStateUnknown{Key: "default/web", Obj: managed}, want: []string{"default/web"}},
+		{name: "deleted by the manager", obj: managed, ownDelete: true},
+		{name: "tombstone deleted by the manager", obj: cache.DeletedFinalStateUnknown{Key: "default/web", Obj: managed}, ownDelete: true},
+		{name: "unmanaged", obj: unmanagedGateway("web")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr, _, services := newTestManager(t, nil)
+			if tt.ownDelete {
+				mgr.expectDelete(managed.UID)
+			}
+			mgr.onGeneratedObjectDeleted(tt.obj)
+			if fmt.Sprint(services.enqueued) != fmt.Sprint(tt.want) {
+				t.Errorf("queued %v, want %v", services.enqueued, tt.want)
+			}
+			if mgr.deletedUIDs.Len() != 0 {
+				t.Errorf("delete of %v is still expected", mgr.deletedUIDs.List())
+			}
+		})
+	}
+}
+
+func TestReconcileDeletedService(t *testing.T) {
+	svc := labeledService("web", "80-30080")
+	svc.Finalizers = []string{FinalizerCleanup}
+	now := metav1.Now()
+	svc.DeletionTimestamp = &now
+
+	state := appliedState(t, labeledService("web", "80-30080"))
+	state.Gateways[0].UID, state.VirtualServices[0].UID, state.DestinationRules[0].UID = "gw-uid", "vs-uid", "dr-uid"
+	objs := []runtime.Object{state.Gateways[0], state.VirtualServices[0], state.DestinationRules[0]}
+	mgr, client, services := newTestManager(t, []*v1.Service{svc}, objs...)
+
+	if err := mgr.reconcile("default/web"); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if got := existing(t, client, "web"); len(got) != 0 {
+		t.Errorf("%v exist after the service was deleted", got)
+	}
+	live, err := mgr.ifm.GetKubeClient().CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service failed: %v", err)
+	}
+	if hasFinalizer(live) {
+		t.Errorf("service is still held by the finalizer")
+	}
+	if got := events(mgr); !hasEvent(got, EventWithdrawn) {
+		t.Errorf("events are %v, want %s", got, EventWithdrawn)
+	}
+	for _, obj := range objs {
+		mgr.onGeneratedObjectDeleted(obj)
+	}
+	if len(services.enqueued) != 0 {
+		t.Errorf("deletes of the withdrawn service queued %v as drift", services.enqueued)
+	}
+}
+
+func TestServerSideApplyConflict(t *testing.T) {
+	tests := []struct {
+		name            string
+		failOnConflicts bool
+		want            applyResult
+		wantErr         bool
+		wantEvent       string
+	}{
+		{name: "fields are taken back", want: resultReclaimed, wantEvent: EventFieldsReclaimed},
+		{name: "conflict fails the apply", failOnConflicts: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := labeledService("web", "80-30080")
+			mgr, _, _ := newTestManager(t, []*v1.Service{svc})
+			mgr.cfg.FailOnConflicts = tt.failOnConflicts
+			live := appliedState(t, svc).Gateways[0]
+			live.ResourceVersion = "1"
+
+			// the patch conflicts unless it is forced, like a field taken over by kubectl edit
+			patch := func(data []byte, opts metav1.PatchOptions) (metav1.Object, error) {
+				if opts.Force == nil || !*opts.Force {
+					return nil, apierrors.NewConflict(istioapi.Resource("gateways"), "web", errors.New("conflict with \"kubectl-edit\""))
+				}
+				applied := live.DeepCopy()
+				applied.ResourceVersion = "2"
+				return applied, nil
+			}
+			got, err := mgr.serverSideApply("Gateway", live, GatewayApplyConfigurationFor(live), patch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply returned %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("apply result is %q, want %q", got, tt.want)
+			}
+
+			mgr.recordApplied(svc, got, resultUnchanged, resultUnchanged, nil)
+			if recorded := events(mgr); tt.wantEvent != "" && !hasEvent(recorded, tt.wantEvent) {
+				t.Errorf("events are %v, want %s", recorded, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestApplyIgnoresForeignFields(t *testing.T) {
+	tests := []struct {
+		name string
+		// edit changes the live gateway like another field manager
+		edit func(gw *istioapi.Gateway)
+		want applyResult
+	}{
+		{name: "no edit", edit: func(*istioapi.Gateway) {}, want: resultUnchanged},
+		{
+			name: "selector label added by others",
+			edit: func(gw *istioapi.Gateway) { gw.Spec.Selector["topology.kubernetes.io/zone"] = "edge" },
+			want: resultUnchanged,
+		},
+		{
+			name: "annotation added by others",
+			edit: func(gw *istioapi.Gateway) { gw.Annotations["kubectl.kubernetes.io/last-applied-configuration"] = "{}" },
+			want: resultUnchanged,
+		},
+		{
+			name: "owned port changed by others",
+			edit: func(gw *istioapi.Gateway) { gw.Spec.Servers[0].Port.Number = 30099 },
+			want: resultUpdated,
+		},
+		{
+			name: "owned server removed by others",
+			edit: func(gw *istioapi.Gateway) { gw.Spec.Servers = nil },
+			want: resultUpdated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := labeledService("web", "80-30080")
+			live := appliedState(t, svc).Gateways[0]
+			live.UID, live.ResourceVersion = "gateway-uid", "1"
+			tt.edit(live)
+			mgr, client, _ := newTestManager(t, []*v1.Service{svc}, live)
+
+			exposure, err := RenderExposure(svc)
+			if err != nil {
+				t.Fatalf("render failed: %v", err)
+			}
+			got, err := mgr.applyGateway(exposure.Gateway)
+			if err != nil {
+				t.Fatalf("apply failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("apply result is %q, want %q", got, tt.want)
+			}
+			patched := 0
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "patch" {
+					patched++
+				}
+			}
+			wantPatches := 1
+			if tt.want == resultUnchanged {
+				wantPatches = 0
+			}
+			if patched != wantPatches {
+				t.Errorf("sent %d patches, want %d", patched, wantPatches)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	earlier := metav1.NewTime(metav1.Now().Add(-time.Hour).Truncate(time.Second))
+	tests := []struct {
+		name string
+		// current is the status on the service before the reconcile
+		current        *ExposureStatus
+		reconcileErr   error
+		wantTransition func(now metav1.Time) metav1.Time
+	}{
+		{
+			name:           "first status",
+			wantTransition: func(now metav1.Time) metav1.Time { return now },
+		},
+		{
+			name:           "unchanged status keeps its transition time",
+			current:        &ExposureStatus{Gateway: "web", VirtualService: "web", DestinationRule: "web", LastReconcileTime: earlier, LastTransitionTime: earlier},
+			wantTransition: func(metav1.Time) metav1.Time { return earlier },
+		},
+		{
+			name:           "changed status",
+			current:        &ExposureStatus{Gateway: "web", VirtualService: "web", DestinationRule: "web", LastReconcileTime: earlier, LastTransitionTime: earlier},
+			reconcileErr:   errors.New("apply gateway rule failed"),
+			wantTransition: func(now metav1.Time) metav1.Time { return now },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := labeledService("web", "80-30080")
+			if tt.current != nil {
+				tt.current.Ports = []PortStatus{{GatewayPort: 30080, Protocol: "HTTP", ServicePort: 80}}
+				data, err := json.Marshal(tt.current)
+				if err != nil {
+					t.Fatalf("marshal status failed: %v", err)
+				}
+				svc.Annotations = map[string]string{AnnotationStatus: string(data)}
+			}
+			mgr, _, _ := newTestManager(t, []*v1.Service{svc})
+
+			status := exposureStatusOf(svc, tt.reconcileErr)
+			if err := mgr.updateStatus(svc, status); err != nil {
+				t.Fatalf("update status failed: %v", err)
+			}
+			live, err := mgr.ifm.GetKubeClient().CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("get service failed: %v", err)
+			}
+			got := &ExposureStatus{}
+			if err := json.Unmarshal([]byte(live.Annotations[AnnotationStatus]), got); err != nil {
+				t.Fatalf("invalid status annotation: %v", err)
+			}
+			// the annotation holds the times in seconds
+			if got.LastReconcileTime.Unix() != status.LastReconcileTime.Unix() {
+				t.Errorf("reconcile time is %v, want the time of this reconcile %v", got.LastReconcileTime, status.LastReconcileTime)
+			}
+			if want := tt.wantTransition(status.LastReconcileTime); got.LastTransitionTime.Unix() != want.Unix() {
+				t.Errorf("transition time is %v, want %v", got.LastTransitionTime, want)
+			}
+		})
+	}
+}
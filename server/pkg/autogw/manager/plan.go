@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlanAction is what reconciling would do to a generated object
+type PlanAction string
+
+const (
+	PlanCreate   PlanAction = "create"
+	PlanUpdate   PlanAction = "update"
+	PlanDelete   PlanAction = "delete"
+	PlanConflict PlanAction = "conflict"
+)
+
+// PlanItem is a change reconciling would make to one object
+type PlanItem struct {
+	Action    PlanAction
+	Kind      string
+	Namespace string
+	Name      string
+	// Diff is the rendered object for a create and the diff to the live object for an update
+	Diff string
+}
+
+// LabelError is a service whose gateway labels can not be parsed
+type LabelError struct {
+	Name  string
+	Error string
+}
+
+// Plan lists the changes reconciling all services would make
+type Plan struct {
+	Items []PlanItem
+	// LabelErrors holds the services with invalid gateway labels by namespace
+	LabelErrors map[string][]LabelError
+}
+
+// Count returns the number of items with action
+func (p *Plan) Count(action PlanAction) int {
+	n := 0
+	for _, item := range p.Items {
+		if item.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// ClusterState is the state a plan is computed from: the services selected by edge-auto-gw and
+// all gateways, virtualservices and destinationrules, managed or not
+type ClusterState struct {
+	Services         []*v1.Service
+	Gateways         []*istioapi.Gateway
+	VirtualServices  []*istioapi.VirtualService
+	DestinationRules []*istioapi.DestinationRule
+}
+
+// planObject is a generated object with its spec, for comparing live and rendered objects
+type planObject struct {
+	kind string
+	meta metav1.Object
+	spec proto.Message
+	ac   interface{}
+}
+
+// BuildPlan computes what reconciling every service of state would change, the same way the
+// controller decides: a live object carrying the hash of the rendering and the fields
+// edge-auto-gw owns is left alone, managed objects without a selected service are deleted.
+func BuildPlan(state *ClusterState, adopt bool) *Plan {
+	plan := &Plan{LabelErrors: make(map[string][]LabelError)}
+	live := state.liveObjects()
+
+	// the objects of selected services are kept, even if their labels are invalid
+	kept := make(map[string]bool)
+	for _, svc := range state.Services {
+		if svc.GetDeletionTimestamp() != nil {
+			continue
+		}
+		for _, kind := range []string{"Gateway", "VirtualService", "DestinationRule"} {
+			kept[kind+"/"+svc.Namespace+"/"+svc.Name] = true
+		}
+
+		exposure, err := RenderExposure(svc)
+		if err != nil {
+			plan.LabelErrors[svc.Namespace] = append(plan.LabelErrors[svc.Namespace], LabelError{Name: svc.Name, Error: err.Error()})
+			continue
+		}
+		for _, desired := range renderedObjects(exposure) {
+			if item, ok := planObjectChange(desired, live[objectKey(desired.kind, desired.meta)], adopt); ok {
+				plan.Items = append(plan.Items, item)
+			}
+		}
+	}
+
+	for key, obj := range live {
+		if kept[key] || !IsManaged(obj.meta) {
+			continue
+		}
+		plan.Items = append(plan.Items, PlanItem{
+			Action: PlanDelete, Kind: obj.kind, Namespace: obj.meta.GetNamespace(), Name: obj.meta.GetName(),
+		})
+	}
+
+	sort.Slice(plan.Items, func(i, j int) bool {
+		a, b := plan.Items[i], plan.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+	return plan
+}
+
+// liveObjects indexes the istio objects of the state by kind, namespace and name
+func (state *ClusterState) liveObjects() map[string]planObject {
+	live := make(map[string]planObject)
+	for _, gw := range state.Gateways {
+		live[objectKey("Gateway", gw)] = planObject{kind: "Gateway", meta: gw, spec: &gw.Spec, ac: GatewayApplyConfigurationFor(gw)}
+	}
+	for _, vs := range state.VirtualServices {
+		live[objectKey("VirtualService", vs)] = planObject{kind: "VirtualService", meta: vs, spec: &vs.Spec, ac: VirtualServiceApplyConfigurationFor(vs)}
+	}
+	for _, dr := range state.DestinationRules {
+		live[objectKey("DestinationRule", dr)] = planObject{kind: "DestinationRule", meta: dr, spec: &dr.Spec, ac: DestinationRuleApplyConfigurationFor(dr)}
+	}
+	return live
+}
+
+func objectKey(kind string, obj metav1.Object) string {
+	return kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// renderedObjects returns the objects of exposure stamped with their spec hash like they are applied
+func renderedObjects(exposure *Exposure) []planObject {
+	dr := exposure.DestinationRule.DeepCopy()
+	vs := exposure.VirtualService.DeepCopy()
+	gw := exposure.Gateway.DeepCopy()
+	objs := []planObject{
+		{kind: "DestinationRule", meta: dr, spec: &dr.Spec, ac: DestinationRuleApplyConfigurationFor(dr)},
+		{kind: "VirtualService", meta: vs, spec: &vs.Spec, ac: VirtualServiceApplyConfigurationFor(vs)},
+		{kind: "Gateway", meta: gw, spec: &gw.Spec, ac: GatewayApplyConfigurationFor(gw)},
+	}
+	for _, obj := range objs {
+		hash, err := specHash(obj.ac)
+		if err != nil {
+			continue
+		}
+		annotations := obj.meta.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[AnnotationSpecHash] = hash
+		obj.meta.SetAnnotations(annotations)
+	}
+	return objs
+}
+
+// planObjectChange returns the change applying desired makes to live, false if there is none
+func planObjectChange(desired, live planObject, adopt bool) (PlanItem, bool) {
+	item := PlanItem{Kind: desired.kind, Namespace: desired.meta.GetNamespace(), Name: desired.meta.GetName()}
+	switch {
+	case live.meta == nil:
+		item.Action = PlanCreate
+		item.Diff = strings.Join(yamlLines(ownedView(desired.meta, desired.meta, desired.spec)), "\n")
+	case !IsManaged(live.meta) && !adopt:
+		item.Action = PlanConflict
+	case upToDate(live.meta, desired.meta.GetAnnotations()[AnnotationSpecHash]) && ownedFieldsMatch(live.ac, desired.ac):
+		return item, false
+	default:
+		item.Action = PlanUpdate
+		item.Diff = objectDiff(live.meta, live.spec, desired.meta, desired.spec)
+	}
+	return item, true
+}
@@ -0,0 +1,189 @@
+package manager
+
+import (
+	"testing"
+
+	istioapi "istio.io/client-go/pkg/apis/networking/v1alpha3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/controller"
+)
+
+func labeledService(name, ports string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name), Labels: map[string]string{
+			controller.LabelEdgemeshGatewayProtocols: "HTTP",
+			controller.LabelEdgemeshGatewayPort:      ports,
+		}},
+		Spec: v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	}
+}
+
+// appliedState returns the state after the objects of svcs were applied
+func appliedState(t *testing.T, svcs ...*v1.Service) *ClusterState {
+	state := &ClusterState{Services: svcs}
+	for _, svc := range svcs {
+		exposure, err := RenderExposure(svc)
+		if err != nil {
+			t.Fatalf("render %s failed: %v", svc.Name, err)
+		}
+		for _, obj := range renderedObjects(exposure) {
+			switch o := obj.meta.(type) {
+			case *istioapi.Gateway:
+				state.Gateways = append(state.Gateways, o)
+			case *istioapi.VirtualService:
+				state.VirtualServices = append(state.VirtualServices, o)
+			case *istioapi.DestinationRule:
+				state.DestinationRules = append(state.DestinationRules, o)
+			}
+		}
+	}
+	return state
+}
+
+func unmanagedGateway(name string) *istioapi.Gateway {
+	return &istioapi.Gateway{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+func TestBuildPlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		state func(t *testing.T) *ClusterState
+		adopt bool
+		// want maps kind/namespace/name to the planned action
+		want            map[string]PlanAction
+		wantLabelErrors int
+	}{
+		{
+			name: "nothing applied yet",
+			state: func(t *testing.T) *ClusterState {
+				return &ClusterState{Services: []*v1.Service{labeledService("web", "80-30080")}}
+			},
+			want: map[string]PlanAction{
+				"Gateway/default/web": PlanCreate, "VirtualService/default/web": PlanCreate, "DestinationRule/default/web": PlanCreate,
+			},
+		},
+		{
+			name:  "applied objects are unchanged",
+			state: func(t *testing.T) *ClusterState { return appliedState(t, labeledService("web", "80-30080")) },
+			want:  map[string]PlanAction{},
+		},
+		{
+			name: "only the hash changed",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Gateways[0].Annotations[AnnotationSpecHash] = "written-by-an-older-version"
+				return state
+			},
+			want: map[string]PlanAction{"Gateway/default/web": PlanUpdate},
+		},
+		{
+			name: "fields set by another field manager are unchanged",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.DestinationRules[0].Spec.ExportTo = []string{"."}
+				state.Gateways[0].Spec.Selector["topology.kubernetes.io/zone"] = "edge"
+				state.Gateways[0].Labels["team"] = "platform"
+				return state
+			},
+			want: map[string]PlanAction{},
+		},
+		{
+			name: "owned field changed by another field manager",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Gateways[0].Spec.Servers[0].Port.Number = 30099
+				return state
+			},
+			want: map[string]PlanAction{"Gateway/default/web": PlanUpdate},
+		},
+		{
+			name: "changed labels update the objects",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Services = []*v1.Service{labeledService("web", "80-30081")}
+				return state
+			},
+			want: map[string]PlanAction{"Gateway/default/web": PlanUpdate},
+		},
+		{
+			name: "unmanaged object in the way is a conflict",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Gateways = []*istioapi.Gateway{unmanagedGateway("web")}
+				return state
+			},
+			want: map[string]PlanAction{"Gateway/default/web": PlanConflict},
+		},
+		{
+			name: "unmanaged object in the way is adopted",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Gateways = []*istioapi.Gateway{unmanagedGateway("web")}
+				return state
+			},
+			adopt: true,
+			want:  map[string]PlanAction{"Gateway/default/web": PlanUpdate},
+		},
+		{
+			name: "unmanaged object without service is left alone",
+			state: func(t *testing.T) *ClusterState {
+				return &ClusterState{Gateways: []*istioapi.Gateway{unmanagedGateway("other")}}
+			},
+			adopt: true,
+			want:  map[string]PlanAction{},
+		},
+		{
+			name: "managed objects without service are deleted",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"), labeledService("gone", "80-30090"))
+				state.Services = state.Services[:1]
+				return state
+			},
+			want: map[string]PlanAction{
+				"Gateway/default/gone": PlanDelete, "VirtualService/default/gone": PlanDelete, "DestinationRule/default/gone": PlanDelete,
+			},
+		},
+		{
+			name: "objects of a service with invalid labels are kept",
+			state: func(t *testing.T) *ClusterState {
+				state := appliedState(t, labeledService("web", "80-30080"))
+				state.Services = []*v1.Service{labeledService("web", "invalid")}
+				return state
+			},
+			want:            map[string]PlanAction{},
+			wantLabelErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := BuildPlan(tt.state(t), tt.adopt)
+
+			got := make(map[string]PlanAction)
+			for _, item := range plan.Items {
+				got[item.Kind+"/"+item.Namespace+"/"+item.Name] = item.Action
+				if (item.Action == PlanCreate || item.Action == PlanUpdate) && item.Diff == "" {
+					t.Errorf("%s %s/%s has no diff", item.Action, item.Kind, item.Name)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("planned %v, want %v", got, tt.want)
+			}
+			for key, action := range tt.want {
+				if got[key] != action {
+					t.Errorf("%s planned as %q, want %q", key, got[key], action)
+				}
+			}
+
+			labelErrors := 0
+			for _, errs := range plan.LabelErrors {
+				labelErrors += len(errs)
+			}
+			if labelErrors != tt.wantLabelErrors {
+				t.Errorf("%d label errors, want %d", labelErrors, tt.wantLabelErrors)
+			}
+		})
+	}
+}
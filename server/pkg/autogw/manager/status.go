@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// AnnotationStatus holds the exposure status of a service as JSON, so tooling can read
+// the exposure state without parsing istio objects or logs
+const AnnotationStatus = "kubeedge.io/edge-auto-gw-status"
+
+// ExposureStatus is the exposure status written to AnnotationStatus
+type ExposureStatus struct {
+	// Ports lists the exposed edgemesh-gateway ports
+	Ports []PortStatus `json:"ports,omitempty"`
+	// Gateway, VirtualService and DestinationRule are the names of the generated objects
+	Gateway         string `json:"gateway,omitempty"`
+	VirtualService  string `json:"virtualService,omitempty"`
+	DestinationRule string `json:"destinationRule,omitempty"`
+	// LastReconcileTime is the time of the last reconcile, it tells the status is current
+	LastReconcileTime metav1.Time `json:"lastReconcileTime"`
+	// LastTransitionTime is the time the rest of the status last changed
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	// LastError is the error of the last reconcile, empty when it succeeded
+	LastError string `json:"lastError,omitempty"`
+}
+
+// PortStatus describes one exposed edgemesh-gateway port
+type PortStatus struct {
+	GatewayPort uint32 `json:"gatewayPort"`
+	Protocol    string `json:"protocol"`
+	ServicePort uint32 `json:"servicePort"`
+}
+
+// exposureStatusOf returns the status of svc after a reconcile which ended with err
+func exposureStatusOf(svc *v1.Service, err error) *ExposureStatus {
+	now := metav1.Now()
+	status := &ExposureStatus{LastReconcileTime: now, LastTransitionTime: now}
+	labelAn, labelErr := Labels(svc.GetLabels()).extractLabels()
+	if labelErr != nil {
+		status.LastError = labelErr.Error()
+		return status
+	}
+	for i := range labelAn.GatewayPort {
+		status.Ports = append(status.Ports, PortStatus{
+			GatewayPort: labelAn.GatewayPort[i],
+			Protocol:    labelAn.GateWayProtocol[i],
+			ServicePort: labelAn.ServicePort[i],
+		})
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		return status
+	}
+	status.Gateway = svc.GetName()
+	status.VirtualService = svc.GetName()
+	status.DestinationRule = svc.GetName()
+	return status
+}
+
+// sameStatus reports whether a and b only differ in their times
+func sameStatus(a, b *ExposureStatus) bool {
+	ac, bc := *a, *b
+	ac.LastReconcileTime, bc.LastReconcileTime = metav1.Time{}, metav1.Time{}
+	ac.LastTransitionTime, bc.LastTransitionTime = metav1.Time{}, metav1.Time{}
+	return reflect.DeepEqual(ac, bc)
+}
+
+// updateStatus writes status to the annotation of svc after every reconcile, the transition
+// time of the current status is kept when nothing but the times changed
+func (mgr *AutoGwManager) updateStatus(svc *v1.Service, status *ExposureStatus) error {
+	if raw, ok := svc.GetAnnotations()[AnnotationStatus]; ok {
+		current := &ExposureStatus{}
+		if err := json.Unmarshal([]byte(raw), current); err == nil && sameStatus(current, status) {
+			status.LastTransitionTime = current.LastTransitionTime
+		}
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("marshal status of service %s.%s failed: %v", svc.Namespace, svc.Name, err)
+	}
+	return mgr.patchStatusAnnotation(svc, string(data))
+}
+
+// clearStatus removes the status annotation from a service which is no longer exposed
+func (mgr *AutoGwManager) clearStatus(svc *v1.Service) error {
+	if _, ok := svc.GetAnnotations()[AnnotationStatus]; !ok {
+		return nil
+	}
+	return mgr.patchStatusAnnotation(svc, nil)
+}
+
+// patchStatusAnnotation sets the status annotation of svc to value, nil removes it
+func (mgr *AutoGwManager) patchStatusAnnotation(svc *v1.Service, value interface{}) error {
+	if mgr.cfg.DryRun {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         svc.GetUID(),
+			"annotations": map[string]interface{}{AnnotationStatus: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = mgr.ifm.GetKubeClient().CoreV1().Services(svc.Namespace).Patch(context.Background(),
+		svc.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("write status of service %s.%s failed: %w", svc.Namespace, svc.Name, err)
+	}
+	klog.V(4).Infof("wrote status of service %s.%s", svc.Namespace, svc.Name)
+	return nil
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// RunOrphanSweep sweeps orphaned gateway resources once at startup and then every period,
+// until stopCh is closed. A period of 0 only runs the startup sweep.
+func (mgr *AutoGwManager) RunOrphanSweep(period time.Duration, stopCh <-chan struct{}) {
+	if period <= 0 {
+		mgr.sweepOrphans()
+		return
+	}
+	wait.Until(mgr.sweepOrphans, period, stopCh)
+}
+
+// sweepOrphans finds the managed gateways, virtualservices and destinationrules whose source
+// service is gone or no longer carries the gateway labels, and queues their service keys.
+// Reconciling a key of a missing service deletes its gateway resources, which cleans up
+// deletions missed while edge-auto-gw was down.
+func (mgr *AutoGwManager) sweepOrphans() {
+	keys, err := mgr.listManagedKeys()
+	if err != nil {
+		klog.Errorf("sweep orphaned gateway resources failed: %v", err)
+		return
+	}
+
+	orphans := 0
+	for _, key := range keys.List() {
+		ns, nm, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			continue
+		}
+		if _, err := mgr.services.GetService(ns, nm); err == nil {
+			continue
+		}
+		klog.Infof("found orphaned gateway resources of service %s", key)
+		mgr.services.Enqueue(key)
+		orphans++
+	}
+	klog.V(4).Infof("swept %d managed services, %d orphaned", keys.Len(), orphans)
+}
+
+// listManagedKeys returns the namespace/name keys of all managed gateway resources
+func (mgr *AutoGwManager) listManagedKeys() (sets.String, error) {
+	client := mgr.ifm.GetIstioClient().NetworkingV1alpha3()
+	keys := sets.NewString()
+	add := func(obj metav1.Object) {
+		keys.Insert(obj.GetNamespace() + "/" + obj.GetName())
+	}
+	opts := metav1.ListOptions{LabelSelector: ManagedSelector().String()}
+
+	drs, err := client.DestinationRules(metav1.NamespaceAll).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range drs.Items {
+		add(&drs.Items[i])
+	}
+
+	vss, err := client.VirtualServices(metav1.NamespaceAll).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vss.Items {
+		add(&vss.Items[i])
+	}
+
+	gws, err := client.Gateways(metav1.NamespaceAll).List(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range gws.Items {
+		add(&gws.Items[i])
+	}
+	return keys, nil
+}
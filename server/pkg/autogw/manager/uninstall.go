@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// UninstallPatch returns a strategic merge patch which removes what edge-auto-gw set on svc:
+// FinalizerCleanup, the status annotation and with stripLabels the gateway labels. It returns
+// false if there is nothing to remove.
+func UninstallPatch(svc *v1.Service, stripLabels bool) ([]byte, bool, error) {
+	metadata := map[string]interface{}{"uid": svc.GetUID()}
+	if hasFinalizer(svc) {
+		metadata["$deleteFromPrimitiveList/finalizers"] = []string{FinalizerCleanup}
+	}
+	if _, ok := svc.GetAnnotations()[AnnotationStatus]; ok {
+		metadata["annotations"] = map[string]interface{}{AnnotationStatus: nil}
+	}
+	if stripLabels {
+		if gl := GatewayLabels(svc.GetLabels()); len(gl) > 0 {
+			l := make(map[string]interface{}, len(gl))
+			for k := range gl {
+				l[k] = nil
+			}
+			metadata["labels"] = l
+		}
+	}
+	if len(metadata) == 1 {
+		return nil, false, nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return data, true, err
+}
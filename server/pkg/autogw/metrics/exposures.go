@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+var (
+	exposuresDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "exposures"),
+		"Number of services exposed on edgemesh-gateway by namespace and protocol.",
+		[]string{"namespace", "protocol"}, nil)
+	gatewayPortsDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "gateway_ports_in_use"),
+		"Number of distinct edgemesh-gateway ports used by the exposed services.",
+		nil, nil)
+)
+
+// Exposure describes the gateway servers generated for one service
+type Exposure struct {
+	Namespace string
+	Protocols []string
+	Ports     []uint32
+}
+
+// exposureCollector reports the current exposures at scrape time, so the numbers
+// always match the generated gateways without bookkeeping on every reconcile
+type exposureCollector struct {
+	list func() ([]Exposure, error)
+}
+
+// RegisterExposureSource registers a collector reporting the exposures list returns
+func RegisterExposureSource(list func() ([]Exposure, error)) {
+	prometheus.MustRegister(&exposureCollector{list: list})
+}
+
+func (c *exposureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- exposuresDesc
+	ch <- gatewayPortsDesc
+}
+
+func (c *exposureCollector) Collect(ch chan<- prometheus.Metric) {
+	exposures, err := c.list()
+	if err != nil {
+		klog.Errorf("list exposures for metrics failed: %v", err)
+		return
+	}
+
+	type nsProtocol struct{ namespace, protocol string }
+	counts := make(map[nsProtocol]int)
+	ports := make(map[uint32]struct{})
+	for _, e := range exposures {
+		seen := make(map[string]struct{})
+		for _, p := range e.Protocols {
+			if _, ok := seen[p]; ok {
+				continue
+			}
+			seen[p] = struct{}{}
+			counts[nsProtocol{e.Namespace, p}]++
+		}
+		for _, port := range e.Ports {
+			ports[port] = struct{}{}
+		}
+	}
+
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(exposuresDesc, prometheus.GaugeValue, float64(n), k.namespace, k.protocol)
+	}
+	ch <- prometheus.MustNewConstMetric(gatewayPortsDesc, prometheus.GaugeValue, float64(len(ports)))
+}
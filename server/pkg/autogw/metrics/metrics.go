@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "edge_auto_gw"
+
+const (
+	// ResultSuccess labels a reconcile which finished without error
+	ResultSuccess = "success"
+	// ResultError labels a reconcile which failed and is retried
+	ResultError = "error"
+)
+
+var (
+	// DriftCorrections counts the generated objects restored after they were changed or deleted by others
+	DriftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "drift_corrections_total",
+		Help:      "Number of generated Istio objects restored after they were changed or deleted by others.",
+	}, []string{"kind"})
+
+	// NamespaceQueueDepth is the number of services of a namespace waiting to be reconciled
+	NamespaceQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "namespace_queue_depth",
+		Help:      "Number of services of a namespace waiting to be reconciled.",
+	}, []string{"namespace"})
+
+	// QueueDepth is the number of services waiting to be reconciled
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workqueue_depth",
+		Help:      "Number of services waiting to be reconciled.",
+	})
+
+	// Reconciles counts the reconciles of a service by result
+	Reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Number of service reconciles by result.",
+	}, []string{"result"})
+
+	// ReconcileDuration observes how long the reconciles of a service take by result
+	ReconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of service reconciles in seconds by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"result"})
+
+	// IstioAPIErrors counts the failed requests to the istio API by verb and kind
+	IstioAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "istio_api_errors_total",
+		Help:      "Number of failed requests to the Istio API by verb and kind.",
+	}, []string{"verb", "kind"})
+
+	// LabelParseFailures counts the reconciles of services whose gateway labels could not be parsed
+	LabelParseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "label_parse_failures_total",
+		Help:      "Number of reconciles of services whose gateway labels could not be parsed by namespace.",
+	}, []string{"namespace"})
+)
+
+func init() {
+	prometheus.MustRegister(DriftCorrections)
+	prometheus.MustRegister(NamespaceQueueDepth)
+	prometheus.MustRegister(QueueDepth)
+	prometheus.MustRegister(Reconciles)
+	prometheus.MustRegister(ReconcileDuration)
+	prometheus.MustRegister(IstioAPIErrors)
+	prometheus.MustRegister(LabelParseFailures)
+}
@@ -12,14 +12,25 @@ import (
 	"github.com/yz271544/edge-auto-gw/server/pkg/autogw/manager"
 )
 
+// stopped is closed once EdgeAutoGw stopped processing services
+var stopped = make(chan struct{})
+
+// Stopped returns a channel which is closed once EdgeAutoGw stopped processing services
+// after beehive was shut down, it is closed right away when the module is disabled
+func Stopped() <-chan struct{} {
+	return stopped
+}
+
 // EdgeAutoGw is a edge ingress gateway
 type EdgeAutoGw struct {
 	Config *config.EdgeAutoGwConfig
+	mgr    *manager.AutoGwManager
 }
 
 func newEdgeAutoGw(c *config.EdgeAutoGwConfig, ifm *informers.Manager) (eag *EdgeAutoGw, err error) {
 	eag = &EdgeAutoGw{Config: c}
 	if !c.Enable {
+		close(stopped)
 		return eag, nil
 	}
 
@@ -27,7 +38,7 @@ func newEdgeAutoGw(c *config.EdgeAutoGwConfig, ifm *informers.Manager) (eag *Edg
 	controller.Init(ifm, c)
 
 	// // new gateway manager
-	manager.NewAutoGwManager(c, ifm)
+	eag.mgr = manager.NewAutoGwManager(c, ifm)
 
 	return eag, nil
 }